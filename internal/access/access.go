@@ -0,0 +1,251 @@
+package access
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/database"
+)
+
+// ErrUnverifiable is returned for a query whose plan cannot be inspected,
+// e.g. because it uses a table function DuckDB cannot serialize, when the
+// principal is subject to restrictions.
+var ErrUnverifiable = errors.New("query cannot be checked against access policies")
+
+// DeniedError reports a table or column the principal may not read.
+type DeniedError struct {
+	Table  TableName
+	Column string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("access to column %s.%s is denied", e.Table, e.Column)
+	}
+	return fmt.Sprintf("access to table %s is denied", e.Table)
+}
+
+// TableName is a fully qualified table name.
+type TableName struct {
+	Catalog string
+	Schema  string
+	Table   string
+}
+
+func (t TableName) String() string {
+	return t.Catalog + "." + t.Schema + "." + t.Table
+}
+
+// TableRef is a table read by a query, with the columns read from it.
+type TableRef struct {
+	TableName
+	Columns []string
+}
+
+// Enforcer applies an access policy to queries.
+type Enforcer struct {
+	db     *database.DB
+	policy *Policy
+}
+
+func NewEnforcer(db *database.DB, policy *Policy) *Enforcer {
+	return &Enforcer{db: db, policy: policy}
+}
+
+// Apply checks query against the policy and returns the query to run in
+// its place: query itself or, if the principal's rows of a table it reads
+// are filtered or its columns masked, query rewritten to read the table
+// only through the filter and masks. It returns a *DeniedError if principal
+// may not read a table or column the query reads and a *PolicyError if a row
+// filter or mask cannot be applied. principal is nil when authentication is
+// disabled. Other errors mean the query could not be planned.
+func (e *Enforcer) Apply(ctx context.Context, principal *auth.Principal, query string) (string, error) {
+	if !e.policy.restricts(principal) {
+		return query, nil
+	}
+
+	refs, err := e.References(ctx, query)
+	if err != nil {
+		return "", err
+	}
+
+	var restricted []restriction
+	for _, ref := range refs {
+		if e.policy.tableEffect(principal, ref.TableName) == Deny {
+			return "", &DeniedError{Table: ref.TableName}
+		}
+
+		r := restriction{table: ref.TableName}
+		for _, column := range ref.Columns {
+			rule := e.policy.columnRule(principal, ref.TableName, column)
+			switch {
+			case rule == nil || rule.Effect == Allow:
+			case rule.Effect == Deny:
+				return "", &DeniedError{Table: ref.TableName, Column: column}
+			default:
+				r.masks = append(r.masks, rule.maskExpression(column)+" AS "+quoteIdent(column))
+			}
+		}
+
+		if f := e.policy.rowFilter(principal, ref.TableName); f != nil {
+			if r.condition, err = f.render(principal); err != nil {
+				return "", &PolicyError{Table: ref.TableName, Err: err}
+			}
+		}
+		if r.condition != "" || len(r.masks) > 0 {
+			restricted = append(restricted, r)
+		}
+	}
+
+	if len(restricted) == 0 {
+		return query, nil
+	}
+	return e.rewrite(ctx, query, restricted)
+}
+
+// serialized is the output of json_serialize_sql and json_serialize_plan.
+type serialized struct {
+	Error        bool          `json:"error"`
+	ErrorType    string        `json:"error_type"`
+	ErrorMessage string        `json:"error_message"`
+	Statements   []interface{} `json:"statements,omitempty"`
+	Plans        []interface{} `json:"plans,omitempty"`
+}
+
+// serialize runs the DuckDB function serializing query to JSON. Numbers are
+// decoded as json.Number, so that they survive being encoded again.
+func (e *Enforcer) serialize(ctx context.Context, function, query string) (*serialized, error) {
+	var out string
+	literal := "'" + strings.ReplaceAll(query, "'", "''") + "'"
+	if err := e.db.GetConnection().QueryRowContext(ctx, "SELECT "+function+"("+literal+")::VARCHAR").Scan(&out); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(out))
+	decoder.UseNumber()
+	var s serialized
+	if err := decoder.Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// plan returns the logical plan DuckDB binds for query.
+func (e *Enforcer) plan(ctx context.Context, query string) ([]interface{}, error) {
+	plan, err := e.serialize(ctx, "json_serialize_plan", query)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Error {
+		if plannerErrors[plan.ErrorType] {
+			return nil, fmt.Errorf("%s error: %s", plan.ErrorType, plan.ErrorMessage)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnverifiable, plan.ErrorMessage)
+	}
+	return plan.Plans, nil
+}
+
+// plannerErrors are the error types of queries that are invalid, rather than
+// merely not serializable.
+var plannerErrors = map[string]bool{
+	"parser":  true,
+	"binder":  true,
+	"catalog": true,
+}
+
+// References returns the tables and columns query reads, taken from the
+// logical plan DuckDB binds for it. Views are resolved to the tables they
+// read.
+func (e *Enforcer) References(ctx context.Context, query string) ([]TableRef, error) {
+	plans, err := e.plan(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make(map[TableName]map[string]bool)
+	for _, p := range plans {
+		collectGets(p, tables)
+	}
+
+	refs := make([]TableRef, 0, len(tables))
+	for name, columns := range tables {
+		ref := TableRef{TableName: name}
+		for column := range columns {
+			ref.Columns = append(ref.Columns, column)
+		}
+		sort.Strings(ref.Columns)
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].String() < refs[j].String() })
+	return refs, nil
+}
+
+// References returns the tables and columns query reads on db, like
+// Enforcer.References, without an access policy.
+func References(ctx context.Context, db *database.DB, query string) ([]TableRef, error) {
+	return (&Enforcer{db: db}).References(ctx, query)
+}
+
+// collectGets records the table scans in a plan. Scans may hang off
+// operators or, for subqueries, off expressions, so every node is visited.
+func collectGets(node interface{}, tables map[TableName]map[string]bool) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			collectGets(child, tables)
+		}
+	case map[string]interface{}:
+		if n["type"] == "LOGICAL_GET" {
+			recordGet(n, tables)
+		}
+		for _, child := range n {
+			collectGets(child, tables)
+		}
+	}
+}
+
+// scannedTable returns the table a LOGICAL_GET scans. ok is false for a
+// table function.
+func scannedTable(get map[string]interface{}) (name TableName, ok bool) {
+	data, _ := get["function_data"].(map[string]interface{})
+	table, _ := data["table"].(string)
+	if table == "" {
+		return name, false
+	}
+	catalog, _ := data["catalog"].(string)
+	schema, _ := data["schema"].(string)
+	return TableName{Catalog: catalog, Schema: schema, Table: table}, true
+}
+
+func recordGet(get map[string]interface{}, tables map[TableName]map[string]bool) {
+	name, ok := scannedTable(get)
+	if !ok {
+		return
+	}
+
+	columns := tables[name]
+	if columns == nil {
+		columns = make(map[string]bool)
+		tables[name] = columns
+	}
+
+	names, _ := get["names"].([]interface{})
+	indexes, _ := get["column_indexes"].([]interface{})
+	for _, index := range indexes {
+		entry, _ := index.(map[string]interface{})
+		number, _ := entry["index"].(json.Number)
+		i, err := number.Int64()
+		// The row ID has an index past the table's columns.
+		if err != nil || i < 0 || int(i) >= len(names) {
+			continue
+		}
+		if column, ok := names[i].(string); ok {
+			columns[column] = true
+		}
+	}
+}
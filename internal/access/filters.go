@@ -0,0 +1,102 @@
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lab1702/goduck/internal/auth"
+)
+
+// placeholder matches a {{...}} reference in a row filter.
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+func validPlaceholder(name string) bool {
+	if name == "principal" {
+		return true
+	}
+	path, ok := strings.CutPrefix(name, "claims.")
+	if !ok {
+		return false
+	}
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// render returns the filter with its placeholders replaced by the
+// principal's values, as SQL literals.
+func (f *RowFilter) render(principal *auth.Principal) (string, error) {
+	var err error
+	condition := placeholder.ReplaceAllStringFunc(f.Filter, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		value, e := placeholderValue(principal, name)
+		if e == nil {
+			var s string
+			if s, e = literal(value); e == nil {
+				return s
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: %w", match, e)
+		}
+		return match
+	})
+	return condition, err
+}
+
+func placeholderValue(principal *auth.Principal, name string) (interface{}, error) {
+	if principal == nil {
+		return nil, errors.New("no principal is authenticated")
+	}
+	if name == "principal" {
+		return principal.Name, nil
+	}
+	path := strings.TrimPrefix(name, "claims.")
+	value, ok := principal.Claim(path)
+	if !ok {
+		return nil, fmt.Errorf("claim %q is not set", path)
+	}
+	return value, nil
+}
+
+// literal returns value, decoded from JSON, as a SQL literal. Arrays become
+// lists.
+func literal(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quote(v), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		if _, err := v.Float64(); err != nil {
+			return "", err
+		}
+		return v.String(), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := literal(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
@@ -0,0 +1,67 @@
+package access
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Masking functions.
+const (
+	// MaskHash replaces values with the hex SHA-256 of their text, so that
+	// equal values still compare, join and group as equal.
+	MaskHash = "hash"
+	// MaskPartial replaces all but the last Keep characters with "****".
+	MaskPartial = "partial"
+	// MaskNull replaces values with NULL.
+	MaskNull = "null"
+	// MaskRound rounds numbers to Digits decimal digits.
+	MaskRound = "round"
+)
+
+const defaultKeep = 4
+
+func (r *Rule) validateMask() error {
+	if r.Effect != Mask {
+		if r.Mask != "" || r.Keep != 0 || r.Digits != 0 {
+			return fmt.Errorf("mask settings require effect %q", Mask)
+		}
+		return nil
+	}
+
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("effect %q requires columns", Mask)
+	}
+	switch r.Mask {
+	case MaskHash, MaskNull:
+	case MaskPartial:
+		if r.Keep < 0 {
+			return fmt.Errorf("keep must not be negative")
+		}
+		if r.Keep == 0 {
+			r.Keep = defaultKeep
+		}
+	case MaskRound:
+	default:
+		return fmt.Errorf("mask must be %q, %q, %q or %q, got %q", MaskHash, MaskPartial, MaskNull, MaskRound, r.Mask)
+	}
+	return nil
+}
+
+// maskExpression returns the SQL expression masking column.
+func (r *Rule) maskExpression(column string) string {
+	ident := quoteIdent(column)
+	text := "CAST(" + ident + " AS VARCHAR)"
+	switch r.Mask {
+	case MaskHash:
+		return "sha256(" + text + ")"
+	case MaskPartial:
+		keep := strconv.Itoa(r.Keep)
+		// NULL stays NULL; values no longer than keep are masked entirely.
+		return "CASE WHEN length(" + text + ") <= " + keep + " THEN '****' ELSE '****' || right(" + text + ", " + keep + ") END"
+	case MaskRound:
+		return "round(" + ident + ", " + strconv.Itoa(r.Digits) + ")"
+	default:
+		// NULL of the column's type.
+		return "CASE WHEN false THEN " + ident + " END"
+	}
+}
@@ -0,0 +1,224 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/lab1702/goduck/internal/auth"
+)
+
+// Rule effects. Mask only applies to columns.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+	Mask  = "mask"
+)
+
+// Rule allows or denies reading a table, or some of its columns, to the
+// principals it applies to, or masks the values of columns. Table is a
+// pattern matched against the "catalog.schema.table" name; it may leave out
+// leading parts and use glob wildcards, e.g. "hr.*" for every table in
+// schema hr. A rule without Roles and Principals applies to everyone.
+type Rule struct {
+	Table      string   `json:"table"`
+	Columns    []string `json:"columns,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	Principals []string `json:"principals,omitempty"`
+	Effect     string   `json:"effect"`
+
+	// Masking, for the mask effect: one of the Mask* functions, with the
+	// number of trailing characters MaskPartial keeps (default 4) and the
+	// decimal digits MaskRound rounds to, negative for tens, hundreds, ...
+	Mask   string `json:"mask,omitempty"`
+	Keep   int    `json:"keep,omitempty"`
+	Digits int    `json:"digits,omitempty"`
+}
+
+// RowFilter limits the rows of a table the principals it applies to can
+// read to those matching Filter, a SQL predicate over the table's columns.
+// Filter may refer to the principal as {{principal}} and to its claims as
+// {{claims.path}}, e.g. "tenant_id = {{claims.tenant}}"; values are
+// substituted as SQL literals.
+type RowFilter struct {
+	Table      string   `json:"table"`
+	Roles      []string `json:"roles,omitempty"`
+	Principals []string `json:"principals,omitempty"`
+	Filter     string   `json:"filter"`
+}
+
+// Policy decides which tables and columns principals may read. For each
+// table, the first matching rule without columns decides, or Default if none
+// matches. For each column of an allowed table, the first matching rule
+// listing the column (or "*") decides whether it is allowed, denied or
+// masked; columns no rule lists are allowed.
+// Rows of a table are limited by the first matching row filter, if any.
+type Policy struct {
+	Default    string      `json:"default"`
+	Rules      []Rule      `json:"rules"`
+	RowFilters []RowFilter `json:"row_filters"`
+}
+
+// LoadPolicy reads a policy file.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy: %w", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse access policy: %w", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	if p.Default == "" {
+		p.Default = Allow
+	}
+	if p.Default != Allow && p.Default != Deny {
+		return fmt.Errorf("access policy default must be %q or %q, got %q", Allow, Deny, p.Default)
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Effect != Allow && r.Effect != Deny && r.Effect != Mask {
+			return fmt.Errorf("access rule %d: effect must be %q, %q or %q, got %q", i, Allow, Deny, Mask, r.Effect)
+		}
+		if err := validateTable(r.Table); err != nil {
+			return fmt.Errorf("access rule %d: %w", i, err)
+		}
+		if err := r.validateMask(); err != nil {
+			return fmt.Errorf("access rule %d: %w", i, err)
+		}
+	}
+
+	for i, f := range p.RowFilters {
+		if err := validateTable(f.Table); err != nil {
+			return fmt.Errorf("row filter %d: %w", i, err)
+		}
+		if strings.TrimSpace(f.Filter) == "" {
+			return fmt.Errorf("row filter %d: filter is required", i)
+		}
+		for _, match := range placeholder.FindAllStringSubmatch(f.Filter, -1) {
+			if !validPlaceholder(match[1]) {
+				return fmt.Errorf("row filter %d: unknown placeholder %q", i, match[0])
+			}
+		}
+	}
+	return nil
+}
+
+func validateTable(table string) error {
+	if table == "" || strings.Count(table, ".") > 2 {
+		return fmt.Errorf("table must be [[catalog.]schema.]table, got %q", table)
+	}
+	for _, part := range strings.Split(table, ".") {
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("invalid table pattern %q", table)
+		}
+	}
+	return nil
+}
+
+// restricts reports whether the policy can deny anything to p or filter the
+// rows it reads.
+func (p *Policy) restricts(principal *auth.Principal) bool {
+	if p.Default == Deny {
+		return true
+	}
+	for _, r := range p.Rules {
+		if r.Effect != Allow && appliesTo(r.Roles, r.Principals, principal) {
+			return true
+		}
+	}
+	for _, f := range p.RowFilters {
+		if appliesTo(f.Roles, f.Principals, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableEffect returns the effect for reading table.
+func (p *Policy) tableEffect(principal *auth.Principal, table TableName) string {
+	for _, r := range p.Rules {
+		if len(r.Columns) == 0 && appliesTo(r.Roles, r.Principals, principal) && matchesTable(r.Table, table) {
+			return r.Effect
+		}
+	}
+	return p.Default
+}
+
+// columnRule returns the rule deciding on reading column of an allowed
+// table, or nil if the column is allowed because no rule lists it.
+func (p *Policy) columnRule(principal *auth.Principal, table TableName, column string) *Rule {
+	for i, r := range p.Rules {
+		if r.listsColumn(column) && appliesTo(r.Roles, r.Principals, principal) && matchesTable(r.Table, table) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// rowFilter returns the row filter for reading table, or nil if its rows are
+// not filtered.
+func (p *Policy) rowFilter(principal *auth.Principal, table TableName) *RowFilter {
+	for i, f := range p.RowFilters {
+		if appliesTo(f.Roles, f.Principals, principal) && matchesTable(f.Table, table) {
+			return &p.RowFilters[i]
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether a rule for roles and principals applies to p.
+// A rule naming neither applies to everyone.
+func appliesTo(roles, principals []string, p *auth.Principal) bool {
+	if len(roles) == 0 && len(principals) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, name := range principals {
+		if name == p.Name {
+			return true
+		}
+	}
+	for _, role := range roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTable reports whether pattern, a [[catalog.]schema.]table pattern,
+// matches table.
+func matchesTable(pattern string, table TableName) bool {
+	patterns := strings.Split(strings.ToLower(pattern), ".")
+	names := []string{table.Catalog, table.Schema, table.Table}
+	names = names[len(names)-len(patterns):]
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, strings.ToLower(names[i])); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) listsColumn(column string) bool {
+	for _, c := range r.Columns {
+		if c == "*" || strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
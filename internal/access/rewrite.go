@@ -0,0 +1,376 @@
+package access
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PolicyError reports a row filter or column mask that cannot be applied to
+// a query. The query must not run, as it could read rows the filter excludes
+// or values the mask hides.
+type PolicyError struct {
+	Table TableName
+	Err   error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("access policy for table %s cannot be applied: %v", e.Table, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errNotSelect = errors.New("only single SELECT statements can read the table")
+	errIndirect  = errors.New("the table is not read by name, e.g. it is read through a view")
+)
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// restriction is how a principal may read a table a query reads: only the
+// rows matching condition, a rendered row filter, and with the masks
+// ("<expression> AS <column>") replacing the columns they hide.
+type restriction struct {
+	table     TableName
+	condition string
+	masks     []string
+}
+
+// rewrite rewrites query so that every reference to a restricted table reads
+// it through a subquery applying the row filter and masks:
+//
+//	FROM orders o  ->  FROM (SELECT * REPLACE (<masks>) FROM orders WHERE <filter> AND <marker>) o
+//
+// The rewrite is done on the statement as parsed by DuckDB and turned back
+// into SQL by it. Each subquery carries a marker, a constant unique to the
+// request and table, so that the plan of the rewritten query can be checked:
+// every scan of a restricted table must sit directly below the filter marked
+// for it. Tables read in any other way, e.g. through a view or a table
+// function, fail the check and the query is refused.
+func (e *Enforcer) rewrite(ctx context.Context, query string, restricted []restriction) (string, error) {
+	parsed, err := e.serialize(ctx, "json_serialize_sql", query)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Error || len(parsed.Statements) != 1 {
+		return "", &PolicyError{Table: restricted[0].table, Err: errNotSelect}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	markers := make(map[TableName]string, len(restricted))
+	subqueries := make(map[TableName]string, len(restricted))
+	for _, r := range restricted {
+		markers[r.table] = "goduck-access-policy:" + hex.EncodeToString(nonce) + ":" + r.table.String()
+		sql := "SELECT *"
+		if len(r.masks) > 0 {
+			sql += " REPLACE (" + strings.Join(r.masks, ", ") + ")"
+		}
+		condition := r.condition
+		if condition == "" {
+			condition = "true"
+		}
+		sql += " FROM t WHERE (\n" + condition + "\n) AND " + quote(markers[r.table]) + " <> ''"
+		s, err := e.serialize(ctx, "json_serialize_sql", sql)
+		if err != nil {
+			return "", err
+		}
+		if s.Error || len(s.Statements) != 1 {
+			return "", &PolicyError{Table: r.table, Err: fmt.Errorf("invalid filter: %s", s.ErrorMessage)}
+		}
+		subquery, err := json.Marshal(s.Statements[0])
+		if err != nil {
+			return "", err
+		}
+		subqueries[r.table] = string(subquery)
+	}
+
+	rw := &rewriter{restricted: restricted, subqueries: subqueries, ctes: make(map[string]bool)}
+	collectCTEs(parsed.Statements[0], rw.ctes)
+	if err := e.keepNames(ctx, parsed.Statements[0], rw); err != nil {
+		return "", err
+	}
+	statement, err := rw.rewrite(parsed.Statements[0])
+	if err != nil {
+		return "", err
+	}
+	parsed.Statements[0] = statement
+
+	rewritten, err := e.deserialize(ctx, parsed)
+	if err != nil {
+		return "", err
+	}
+
+	plans, err := e.plan(ctx, rewritten)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range plans {
+		if err := checkRestricted(p, nil, markers); err != nil {
+			return "", err
+		}
+	}
+	return rewritten, nil
+}
+
+// keepNames aliases the result columns of statement whose expressions read
+// a restricted table to the names DuckDB gives them unrewritten. Without an
+// alias, such a column would be named after the rewritten expression,
+// which shows the row filter and masks applied to the principal.
+func (e *Enforcer) keepNames(ctx context.Context, statement interface{}, rw *rewriter) error {
+	// The result columns are named after the leftmost SELECT.
+	node, _ := statement.(map[string]interface{})["node"].(map[string]interface{})
+	for node != nil && node["type"] != "SELECT_NODE" {
+		node, _ = node["left"].(map[string]interface{})
+	}
+	if node == nil {
+		return nil
+	}
+
+	selectList, _ := node["select_list"].([]interface{})
+	for _, item := range selectList {
+		expression, _ := item.(map[string]interface{})
+		if expression == nil || expression["alias"] != "" ||
+			expression["class"] == "COLUMN_REF" || expression["class"] == "STAR" || !rw.reads(expression) {
+			continue
+		}
+
+		// An unaliased expression is named after its SQL.
+		doc, err := e.serialize(ctx, "json_serialize_sql", "SELECT NULL")
+		if err != nil {
+			return err
+		}
+		doc.Statements[0].(map[string]interface{})["node"].(map[string]interface{})["select_list"] = []interface{}{expression}
+		sql, err := e.deserialize(ctx, doc)
+		if err != nil {
+			return err
+		}
+		expression["alias"] = strings.TrimPrefix(sql, "SELECT ")
+	}
+	return nil
+}
+
+// deserialize returns the SQL of a statement parsed by json_serialize_sql.
+func (e *Enforcer) deserialize(ctx context.Context, doc *serialized) (string, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	var sql string
+	if err := e.db.GetConnection().QueryRowContext(ctx, "SELECT json_deserialize_sql("+quote(string(b))+"::JSON)").Scan(&sql); err != nil {
+		return "", err
+	}
+	return sql, nil
+}
+
+// rewriter replaces references to restricted tables in a parsed statement.
+type rewriter struct {
+	restricted []restriction
+	// subqueries holds the parsed subquery of each table.
+	subqueries map[TableName]string
+	// ctes holds the lower-cased names of the statement's CTEs, which
+	// unqualified references may refer to instead of tables.
+	ctes map[string]bool
+}
+
+func (r *rewriter) rewrite(node interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case []interface{}:
+		for i, child := range n {
+			rewritten, err := r.rewrite(child)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = rewritten
+		}
+	case map[string]interface{}:
+		if n["type"] == "BASE_TABLE" {
+			if restricted := r.restrictionFor(n); restricted != nil {
+				return r.subquery(n, restricted.table)
+			}
+			return n, nil
+		}
+		for key, child := range n {
+			rewritten, err := r.rewrite(child)
+			if err != nil {
+				return nil, err
+			}
+			n[key] = rewritten
+		}
+	}
+	return node, nil
+}
+
+// reads reports whether node refers to a restricted table.
+func (r *rewriter) reads(node interface{}) bool {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			if r.reads(child) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		if n["type"] == "BASE_TABLE" {
+			return r.restrictionFor(n) != nil
+		}
+		for _, child := range n {
+			if r.reads(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// restrictionFor returns the restriction of the table ref refers to, or nil
+// if it refers to no restricted table.
+func (r *rewriter) restrictionFor(ref map[string]interface{}) *restriction {
+	catalog, _ := ref["catalog_name"].(string)
+	schema, _ := ref["schema_name"].(string)
+	table, _ := ref["table_name"].(string)
+	if catalog == "" && schema == "" && r.ctes[strings.ToLower(table)] {
+		return nil
+	}
+	for i, restricted := range r.restricted {
+		if strings.EqualFold(table, restricted.table.Table) &&
+			(schema == "" || strings.EqualFold(schema, restricted.table.Schema)) &&
+			(catalog == "" || strings.EqualFold(catalog, restricted.table.Catalog)) {
+			return &r.restricted[i]
+		}
+	}
+	return nil
+}
+
+// subquery returns the subquery of table reading ref, taking over
+// ref's alias and sample.
+func (r *rewriter) subquery(ref map[string]interface{}, table TableName) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(r.subqueries[table]))
+	decoder.UseNumber()
+	var statement map[string]interface{}
+	if err := decoder.Decode(&statement); err != nil {
+		return nil, err
+	}
+
+	alias := ref["alias"]
+	if alias == "" {
+		alias = ref["table_name"]
+	}
+	wrapped := map[string]interface{}{
+		"type":              "SUBQUERY",
+		"alias":             alias,
+		"sample":            ref["sample"],
+		"query_location":    ref["query_location"],
+		"subquery":          statement,
+		"column_name_alias": ref["column_name_alias"],
+	}
+
+	ref["alias"] = ""
+	ref["sample"] = nil
+	ref["column_name_alias"] = []interface{}{}
+	node, _ := statement["node"].(map[string]interface{})
+	node["from_table"] = ref
+	return wrapped, nil
+}
+
+// collectCTEs records the names of the CTEs defined anywhere in node.
+func collectCTEs(node interface{}, ctes map[string]bool) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			collectCTEs(child, ctes)
+		}
+	case map[string]interface{}:
+		if cteMap, ok := n["cte_map"].(map[string]interface{}); ok {
+			entries, _ := cteMap["map"].([]interface{})
+			for _, entry := range entries {
+				e, _ := entry.(map[string]interface{})
+				if name, ok := e["key"].(string); ok {
+					ctes[strings.ToLower(name)] = true
+				}
+			}
+		}
+		for _, child := range n {
+			collectCTEs(child, ctes)
+		}
+	}
+}
+
+// checkRestricted returns a *PolicyError if a scan of a table in markers
+// does not sit directly below a filter carrying the table's marker. parent
+// is the operator node is a child of, or nil if node hangs off an
+// expression.
+func checkRestricted(node interface{}, parent map[string]interface{}, markers map[TableName]string) error {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			if err := checkRestricted(child, parent, markers); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		operator, _ := n["type"].(string)
+		if !strings.HasPrefix(operator, "LOGICAL_") {
+			for _, child := range n {
+				if err := checkRestricted(child, parent, markers); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if operator == "LOGICAL_GET" {
+			if table, ok := scannedTable(n); ok {
+				if marker, filtered := markers[table]; filtered &&
+					(parent == nil || parent["type"] != "LOGICAL_FILTER" || !containsString(parent["expressions"], marker)) {
+					return &PolicyError{Table: table, Err: errIndirect}
+				}
+			}
+		}
+		for key, child := range n {
+			p := map[string]interface{}(nil)
+			if key == "children" {
+				p = n
+			}
+			if err := checkRestricted(child, p, markers); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(node interface{}, s string) bool {
+	switch n := node.(type) {
+	case string:
+		return n == s
+	case []interface{}:
+		for _, child := range n {
+			if containsString(child, s) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, child := range n {
+			if containsString(child, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+// Package admission limits the number of queries running at once, queueing
+// the others by priority.
+package admission
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lab1702/goduck/internal/metrics"
+)
+
+// Priority is the class a query waits in. Interactive queries are admitted
+// before any batch query.
+type Priority int
+
+const (
+	Interactive Priority = iota
+	Batch
+	priorities
+)
+
+func (p Priority) String() string {
+	if p == Batch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// ParsePriority parses "interactive" or "batch".
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "interactive":
+		return Interactive, nil
+	case "batch":
+		return Batch, nil
+	}
+	return 0, fmt.Errorf("invalid priority %q: must be interactive or batch", s)
+}
+
+var (
+	ErrQueueFull    = errors.New("too many queries waiting to run")
+	ErrQueueTimeout = errors.New("timed out waiting for a query slot")
+)
+
+// TooManyQueriesError is returned by Reserve when a principal already has
+// as many queries running or waiting as its roles allow.
+type TooManyQueriesError struct {
+	Max int
+}
+
+func (e *TooManyQueriesError) Error() string {
+	return fmt.Sprintf("too many queries in progress: your roles allow %d at once", e.Max)
+}
+
+// RoleLimit is the ceiling on the queries of principals with a role.
+type RoleLimit struct {
+	// MaxConcurrent is the number of queries each principal may have
+	// running or waiting at once; zero is unlimited.
+	MaxConcurrent int
+	// MaxPriority is the highest priority its queries may run at.
+	MaxPriority Priority
+}
+
+// ParseRoleLimits parses role ceilings such as
+// "analyst=concurrent:2;etl=concurrent:4,priority:batch".
+func ParseRoleLimits(s string) (map[string]RoleLimit, error) {
+	limits := make(map[string]RoleLimit)
+	for _, grant := range strings.Split(s, ";") {
+		if strings.TrimSpace(grant) == "" {
+			continue
+		}
+		role, list, ok := strings.Cut(grant, "=")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid role limit %q, expected role=concurrent:N,priority:P", grant)
+		}
+		var limit RoleLimit
+		for _, setting := range strings.Split(list, ",") {
+			name, value, _ := strings.Cut(setting, ":")
+			var err error
+			switch strings.TrimSpace(name) {
+			case "concurrent":
+				limit.MaxConcurrent, err = strconv.Atoi(strings.TrimSpace(value))
+				if err == nil && limit.MaxConcurrent < 1 {
+					err = errors.New("must be at least 1")
+				}
+			case "priority":
+				limit.MaxPriority, err = ParsePriority(value)
+			default:
+				err = errors.New("unknown setting")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("role %q: invalid limit %q: %v", role, strings.TrimSpace(setting), err)
+			}
+		}
+		limits[role] = limit
+	}
+	return limits, nil
+}
+
+// Config sizes the controller.
+type Config struct {
+	// MaxConcurrent is the number of queries run at once.
+	MaxConcurrent int
+	// QueueSize is the number of queries that may wait, of all priorities.
+	QueueSize int
+	// QueueTimeout bounds how long a query waits.
+	QueueTimeout time.Duration
+	// BatchPrincipals are the principals whose queries are always batch.
+	BatchPrincipals []string
+	// RoleLimits caps the queries of principals by role.
+	RoleLimits map[string]RoleLimit
+}
+
+// Stats is a snapshot of the controller.
+type Stats struct {
+	Running       int
+	MaxConcurrent int
+	Waiting       [priorities]int
+}
+
+type waiter struct {
+	priority Priority
+	ready    chan struct{}
+	granted  bool
+}
+
+// Controller admits queries up to a concurrency limit. Queries beyond it
+// wait in a bounded queue per priority, first in, first out.
+type Controller struct {
+	cfg   Config
+	batch map[string]bool
+
+	mutex   sync.Mutex
+	running int
+	queues  [priorities]list.List
+	// reserved counts the queries of each principal reserved by Reserve.
+	reserved map[string]int
+}
+
+// NewController returns a controller sized by cfg.
+func NewController(cfg Config) *Controller {
+	c := &Controller{cfg: cfg, batch: make(map[string]bool), reserved: make(map[string]int)}
+	for _, name := range cfg.BatchPrincipals {
+		c.batch[name] = true
+	}
+	return c
+}
+
+// Priority returns the priority of a query by principal, which may be empty,
+// that asked for the requested priority. Principals configured as batch
+// cannot ask for interactive priority.
+func (c *Controller) Priority(principal string, requested Priority) Priority {
+	if c.batch[principal] {
+		return Batch
+	}
+	return requested
+}
+
+// RoleLimit returns the ceiling of a principal with roles: the most
+// permissive of its roles' limits. ok is false if none of its roles is
+// limited.
+func (c *Controller) RoleLimit(roles []string) (limit RoleLimit, ok bool) {
+	limit.MaxPriority = Batch
+	for _, role := range roles {
+		l, found := c.cfg.RoleLimits[role]
+		if !found {
+			continue
+		}
+		if !ok || l.MaxConcurrent == 0 || (limit.MaxConcurrent != 0 && l.MaxConcurrent > limit.MaxConcurrent) {
+			limit.MaxConcurrent = l.MaxConcurrent
+		}
+		limit.MaxPriority = min(limit.MaxPriority, l.MaxPriority)
+		ok = true
+	}
+	if !ok {
+		return RoleLimit{}, false
+	}
+	return limit, true
+}
+
+// Reserve counts a query of principal against max, the number of queries
+// it may have in progress, and returns the function ending the
+// reservation. It fails with a *TooManyQueriesError if principal already
+// has max queries in progress. A zero max is unlimited.
+func (c *Controller) Reserve(principal string, max int) (release func(), err error) {
+	if max <= 0 {
+		return func() {}, nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.reserved[principal] >= max {
+		return nil, &TooManyQueriesError{Max: max}
+	}
+	c.reserved[principal]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if c.reserved[principal]--; c.reserved[principal] == 0 {
+				delete(c.reserved, principal)
+			}
+		})
+	}, nil
+}
+
+// QueueTimeout returns how long a query waits at most.
+func (c *Controller) QueueTimeout() time.Duration {
+	return c.cfg.QueueTimeout
+}
+
+// Acquire waits for a query slot and returns the function releasing it. It
+// fails with ErrQueueFull if the queue is full, ErrQueueTimeout if no slot
+// became free in time, or the error of ctx.
+func (c *Controller) Acquire(ctx context.Context, priority Priority) (release func(), err error) {
+	c.mutex.Lock()
+	if c.running < c.cfg.MaxConcurrent && c.waiting() == 0 {
+		c.running++
+		c.report()
+		c.mutex.Unlock()
+		metrics.ObserveQueueWait(priority.String(), 0)
+		return c.releaser(), nil
+	}
+	if c.waiting() >= c.cfg.QueueSize {
+		c.mutex.Unlock()
+		metrics.QueueRejected(priority.String(), "full")
+		return nil, ErrQueueFull
+	}
+	w := &waiter{priority: priority, ready: make(chan struct{})}
+	elem := c.queues[priority].PushBack(w)
+	c.report()
+	c.mutex.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(c.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		metrics.ObserveQueueWait(priority.String(), time.Since(start))
+		return c.releaser(), nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mutex.Lock()
+	granted := w.granted
+	if !granted {
+		c.queues[priority].Remove(elem)
+		c.report()
+	}
+	c.mutex.Unlock()
+	if granted {
+		// The slot was handed over as the wait ended; pass it on.
+		c.releaser()()
+	}
+	if errors.Is(err, ErrQueueTimeout) {
+		metrics.QueueRejected(priority.String(), "timeout")
+	}
+	return nil, err
+}
+
+// Stats returns the number of queries running and waiting.
+func (c *Controller) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := Stats{Running: c.running, MaxConcurrent: c.cfg.MaxConcurrent}
+	for p := range c.queues {
+		s.Waiting[p] = c.queues[p].Len()
+	}
+	return s
+}
+
+// releaser returns the function releasing a slot, which hands it to the
+// first waiter of the highest priority. Calls after the first do nothing.
+func (c *Controller) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.running--
+			for p := range c.queues {
+				if front := c.queues[p].Front(); front != nil && c.running < c.cfg.MaxConcurrent {
+					w := c.queues[p].Remove(front).(*waiter)
+					w.granted = true
+					c.running++
+					close(w.ready)
+					break
+				}
+			}
+			c.report()
+		})
+	}
+}
+
+// waiting returns the number of queued queries. It is called with the
+// mutex held.
+func (c *Controller) waiting() int {
+	n := 0
+	for p := range c.queues {
+		n += c.queues[p].Len()
+	}
+	return n
+}
+
+// report updates the queue metrics. It is called with the mutex held.
+func (c *Controller) report() {
+	metrics.SetQueriesRunning(c.running)
+	for p := range c.queues {
+		metrics.SetQueueDepth(Priority(p).String(), c.queues[p].Len())
+	}
+}
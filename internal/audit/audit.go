@@ -0,0 +1,217 @@
+// Package audit keeps a tamper-evident record of every statement run. Each
+// record is a line of JSON carrying the SHA-256 hash of its own content and
+// of the record before it, so editing, removing or reordering records breaks
+// the chain.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outcomes of a statement.
+const (
+	OutcomeSuccess   = "success"
+	OutcomeDenied    = "denied"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// maxLineSize bounds the length of a record when reading the log back.
+const maxLineSize = 1 << 20
+
+// Record is the audit record of one statement.
+type Record struct {
+	// Time is when the statement finished.
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	JobID      string    `json:"job_id,omitempty"`
+	Principal  string    `json:"principal,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	SQL        string    `json:"sql"`
+	Class      string    `json:"statement_class,omitempty"`
+	Tables     []string  `json:"tables,omitempty"`
+	Rows       int64     `json:"rows"`
+	DurationMS float64   `json:"duration_ms"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// digest returns the hash of the record's content, including the hash of
+// the record before it.
+func (r Record) digest() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log appends records to a JSONL file. When the file would grow past
+// maxSize it is rotated to path.1, path.1 to path.2 and so on, keeping
+// maxFiles rotated files. The hash chain continues across files.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	last  string
+}
+
+// Open opens the log at path, continuing the hash chain of the records
+// already in it.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+
+	// The newest record is in the current file or, right after a
+	// rotation, in the newest rotated one.
+	for _, name := range []string{path, l.rotated(1)} {
+		last, err := lastRecord(name)
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.last = last.Hash
+			break
+		}
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write appends r to the log, setting its time if unset and chaining it to
+// the previous record.
+func (l *Log) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return os.ErrClosed
+	}
+
+	r.PrevHash = l.last
+	r.Hash = r.digest()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating audit log: %w", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	l.last = r.Hash
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Log) rotated(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+func (l *Log) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if err := os.Remove(l.rotated(l.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(l.rotated(n), l.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.rotated(1)); err != nil {
+		return err
+	}
+	return l.openFile()
+}
+
+// lastRecord returns the last record in the file at path, or nil if there
+// is none.
+func lastRecord(path string) (*Record, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	var r Record
+	if err := json.Unmarshal(last, &r); err != nil || r.Hash == "" {
+		return nil, fmt.Errorf("%s ends with a malformed audit record", path)
+	}
+	return &r, nil
+}
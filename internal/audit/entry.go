@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lab1702/goduck/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const entryKey = "audit_entry"
+
+// Entry collects the audit record of a request's statement while the
+// request is handled. Its methods do nothing on a nil Entry, which is what
+// EntryFrom returns when auditing is disabled.
+type Entry struct {
+	log      *Log
+	observe  func(Record)
+	started  time.Time
+	record   Record
+	duration time.Duration
+	detached bool
+}
+
+// Begin starts the audit entry of the request, which the request's handler
+// fills in. The record is written to log, if not nil, and passed to
+// observe, if not nil.
+func Begin(c *gin.Context, log *Log, observe func(Record)) *Entry {
+	e := &Entry{
+		log:     log,
+		observe: observe,
+		started: time.Now(),
+		record: Record{
+			RequestID: c.GetString("request_id"),
+			ClientIP:  c.ClientIP(),
+		},
+	}
+	if p := auth.PrincipalFrom(c); p != nil {
+		e.record.Principal = p.Name
+	}
+	c.Set(entryKey, e)
+	return e
+}
+
+// EntryFrom returns the request's audit entry, or nil if the request is not
+// audited.
+func EntryFrom(c *gin.Context) *Entry {
+	if v, ok := c.Get(entryKey); ok {
+		return v.(*Entry)
+	}
+	return nil
+}
+
+// Statement records the statement the request runs and its class. Requests
+// without a statement are not audited.
+func (e *Entry) Statement(sql, class string) {
+	if e == nil {
+		return
+	}
+	e.record.SQL = Normalize(sql)
+	e.record.Class = class
+}
+
+// Logged reports whether the record is written to an audit log, rather
+// than only observed.
+func (e *Entry) Logged() bool {
+	return e != nil && e.log != nil
+}
+
+// Tables records the tables the statement reads.
+func (e *Entry) Tables(tables []string) {
+	if e != nil {
+		e.record.Tables = tables
+	}
+}
+
+// Rows records the number of rows the statement returned.
+func (e *Entry) Rows(n int64) {
+	if e != nil {
+		e.record.Rows = n
+	}
+}
+
+// Job records the ID of the job running the statement.
+func (e *Entry) Job(id string) {
+	if e != nil {
+		e.record.JobID = id
+	}
+}
+
+// Duration overrides the duration recorded, which is otherwise the time
+// from Begin to the end of the request.
+func (e *Entry) Duration(d time.Duration) {
+	if e != nil {
+		e.duration = d
+	}
+}
+
+// Fail records that the statement did not succeed.
+func (e *Entry) Fail(outcome string, err error) {
+	if e == nil {
+		return
+	}
+	e.record.Outcome = outcome
+	if err != nil {
+		e.record.Error = err.Error()
+	}
+}
+
+// Detach keeps End from writing the record, for statements that finish
+// after their request, e.g. jobs. The caller writes it with Write.
+func (e *Entry) Detach() {
+	if e != nil {
+		e.detached = true
+	}
+}
+
+// End writes the record when the request is done, unless the request ran
+// no statement or the entry was detached. Requests that failed without
+// recording an outcome are given one from their status code.
+func (e *Entry) End(status int) {
+	if e == nil || e.detached || e.record.SQL == "" {
+		return
+	}
+	if e.record.Outcome == "" {
+		switch {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			e.record.Outcome = OutcomeDenied
+		case status >= http.StatusBadRequest:
+			e.record.Outcome = OutcomeFailed
+		}
+	}
+	e.Write()
+}
+
+// Write writes the record to the log and passes it to the observer.
+func (e *Entry) Write() {
+	if e == nil {
+		return
+	}
+	if e.record.Outcome == "" {
+		e.record.Outcome = OutcomeSuccess
+	}
+	if e.duration == 0 {
+		e.duration = time.Since(e.started)
+	}
+	e.record.DurationMS = float64(e.duration.Microseconds()) / 1000
+
+	if e.observe != nil {
+		e.observe(e.record)
+	}
+	if e.log == nil {
+		return
+	}
+	if err := e.log.Write(e.record); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"request_id": e.record.RequestID,
+			"job_id":     e.record.JobID,
+		}).Error("Failed to write audit record")
+	}
+}
+
+// Normalize strips comments from sql and collapses runs of white space
+// outside quoted strings and identifiers to a single space.
+func Normalize(sql string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(sql); {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			end := quoteEnd(sql, i)
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteString(sql[i:end])
+			i = end
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end
+			space = true
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f':
+			space = true
+			i++
+		default:
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// quoteEnd returns the index just past the quoted string or identifier
+// starting at sql[start]. A doubled quote character is an escaped one.
+func quoteEnd(sql string, start int) int {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != quote {
+			continue
+		}
+		if i+1 < len(sql) && sql[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(sql)
+}
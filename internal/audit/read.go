@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Filter selects audit records. Zero fields match every record.
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Principal string
+	// Limit is the maximum number of records returned.
+	Limit int
+}
+
+func (f Filter) matches(r *Record) bool {
+	if !f.From.IsZero() && r.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.Time.Before(f.To) {
+		return false
+	}
+	return f.Principal == "" || r.Principal == f.Principal
+}
+
+// Read returns the records matching filter, newest first.
+func (l *Log) Read(filter Filter) ([]Record, error) {
+	var matched []Record
+	err := l.scan(func(r *Record, _ string, _ int) error {
+		if !filter.matches(r) {
+			return nil
+		}
+		matched = append(matched, *r)
+		// Only the newest Limit records are returned.
+		if filter.Limit > 0 && len(matched) > filter.Limit {
+			matched = matched[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, nil
+}
+
+// Verify checks the hash chain of the records in the log. The first record
+// kept may follow records that have been rotated out, so the chain is
+// anchored at whatever record it names as its predecessor.
+func (l *Log) Verify() error {
+	prev := ""
+	first := true
+	return l.scan(func(r *Record, file string, line int) error {
+		if r.digest() != r.Hash {
+			return fmt.Errorf("%s:%d: audit record does not match its hash", file, line)
+		}
+		if !first && r.PrevHash != prev {
+			return fmt.Errorf("%s:%d: audit record does not follow the record before it", file, line)
+		}
+		prev, first = r.Hash, false
+		return nil
+	})
+}
+
+// segment is a log file and the length of it to read.
+type segment struct {
+	name string
+	file *os.File
+	size int64
+}
+
+// scan calls fn with every record in the log, oldest first.
+func (l *Log) scan(fn func(r *Record, file string, line int) error) error {
+	segments, err := l.snapshot()
+	defer func() {
+		for _, s := range segments {
+			s.file.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		scanner := bufio.NewScanner(io.LimitReader(s.file, s.size))
+		scanner.Buffer(nil, maxLineSize)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				return fmt.Errorf("%s:%d: malformed audit record: %w", s.name, line, err)
+			}
+			if err := fn(&r, s.name, line); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// snapshot opens the log files, oldest first, while no record is being
+// written, so that they can be read consistently while writing goes on.
+// Open files stay readable when they are rotated or removed.
+func (l *Log) snapshot() ([]segment, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var segments []segment
+	for n := l.maxFiles; n >= 0; n-- {
+		name := l.path
+		if n > 0 {
+			name = l.rotated(n)
+		}
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return segments, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return segments, err
+		}
+		segments = append(segments, segment{name: name, file: file, size: info.Size()})
+	}
+	return segments, nil
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes granted to principals.
+const (
+	ScopeQueryRead  = "query:read"  // run SELECT and EXPLAIN queries, read jobs
+	ScopeQueryWrite = "query:write" // run every other statement class
+	ScopeAdmin      = "admin"       // list and cancel running queries
+	ScopeMetrics    = "metrics"     // read /metrics
+)
+
+var scopes = []string{ScopeQueryRead, ScopeQueryWrite, ScopeAdmin, ScopeMetrics}
+
+// ErrInvalidCredential is returned by an Authenticator for a credential it
+// does not accept.
+var ErrInvalidCredential = errors.New("invalid credential")
+
+// Principal is the authenticated caller of a request. Roles and Claims are
+// set from the API key file, JWT or client certificate the principal
+// authenticated with.
+type Principal struct {
+	Name   string
+	Scopes []string
+	Roles  []string
+	Claims map[string]interface{}
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Claim resolves a dotted path, e.g. "org.tenant", in the principal's
+// claims. ok is false if the claim does not exist.
+func (p *Principal) Claim(path string) (value interface{}, ok bool) {
+	return claim(p.Claims, path)
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies the credential presented with a request.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (*Principal, error)
+}
+
+// contextKey is the gin context key holding the request's *Principal.
+const contextKey = "principal"
+
+// SetPrincipal attaches p to the request.
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(contextKey, p)
+}
+
+// PrincipalFrom returns the request's principal, or nil if authentication
+// is disabled.
+func PrincipalFrom(c *gin.Context) *Principal {
+	p, _ := c.Get(contextKey)
+	principal, _ := p.(*Principal)
+	return principal
+}
+
+// Allowed reports whether the request may use scope. Without a principal,
+// authentication is disabled and everything is allowed.
+func Allowed(c *gin.Context, scope string) bool {
+	p := PrincipalFrom(c)
+	return p == nil || p.HasScope(scope)
+}
+
+func knownScope(scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
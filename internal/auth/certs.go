@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+// CertificateAuthenticator authenticates verified TLS client certificates.
+type CertificateAuthenticator interface {
+	AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (*Principal, error)
+}
+
+// CertAuthenticator turns verified client certificates into principals. The
+// certificate's subject names the principal and its organizational units are
+// its roles, which RoleScopes grants scopes. It accepts no other
+// credentials.
+type CertAuthenticator struct {
+	roleScopes map[string][]string
+}
+
+func NewCertAuthenticator(roleScopes map[string][]string) *CertAuthenticator {
+	return &CertAuthenticator{roleScopes: roleScopes}
+}
+
+func (a *CertAuthenticator) Authenticate(context.Context, string) (*Principal, error) {
+	return nil, ErrInvalidCredential
+}
+
+// AuthenticateCertificate returns the principal of cert, which the TLS
+// handshake has verified against the client CAs. The certificate's subject
+// fields are available to access policies as claims.
+func (a *CertAuthenticator) AuthenticateCertificate(_ context.Context, cert *x509.Certificate) (*Principal, error) {
+	subject := cert.Subject.String()
+	if subject == "" {
+		return nil, ErrInvalidCredential
+	}
+
+	roles := cert.Subject.OrganizationalUnit
+	return &Principal{
+		Name:   subject,
+		Roles:  roles,
+		Scopes: grantScopes(a.roleScopes, roles),
+		Claims: map[string]interface{}{
+			"subject":             subject,
+			"common_name":         cert.Subject.CommonName,
+			"organization":        stringsToList(cert.Subject.Organization),
+			"organizational_unit": stringsToList(roles),
+			"email_addresses":     stringsToList(cert.EmailAddresses),
+			"dns_names":           stringsToList(cert.DNSNames),
+			"serial_number":       cert.SerialNumber.String(),
+		},
+	}, nil
+}
+
+// AuthenticateCertificate tries each certificate authenticator in the chain
+// in turn, returning the first principal.
+func (ch Chain) AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (*Principal, error) {
+	err := ErrInvalidCredential
+	for _, a := range ch {
+		ca, ok := a.(CertificateAuthenticator)
+		if !ok {
+			continue
+		}
+		p, e := ca.AuthenticateCertificate(ctx, cert)
+		if e == nil {
+			return p, nil
+		}
+		err = e
+	}
+	return nil, err
+}
+
+// stringsToList converts values to a claim list, as decoded from JSON.
+func stringsToList(values []string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
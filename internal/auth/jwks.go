@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxJWKSSize bounds the size of a fetched key set.
+const maxJWKSSize = 1 << 20
+
+// jwk is a JSON Web Key; only the members of RSA, P-256 and Ed25519 public
+// signing keys are decoded.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a verification key and the algorithm it is used with.
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// JWKS is a cached JSON Web Key Set read from a file or URL. It is reloaded
+// when it is older than its TTL, or sooner when a token names an unknown key
+// ID, which is how key rotation becomes visible.
+type JWKS struct {
+	load func(ctx context.Context) ([]byte, error)
+	ttl  time.Duration
+
+	mutex       sync.Mutex
+	keys        map[string]publicKey
+	loaded      time.Time
+	lastAttempt time.Time
+	// refreshing is closed once the reload in progress, if any, is done.
+	refreshing chan struct{}
+}
+
+// NewJWKSFile returns a key set read from path.
+func NewJWKSFile(path string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		load: func(context.Context) ([]byte, error) { return os.ReadFile(path) },
+		ttl:  ttl,
+	}
+}
+
+// NewJWKSURL returns a key set fetched from url.
+func NewJWKSURL(url string, ttl time.Duration) *JWKS {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &JWKS{
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+		},
+		ttl: ttl,
+	}
+}
+
+// Load reads the key set, failing if it holds no usable key.
+func (s *JWKS) Load(ctx context.Context) error {
+	keys, err := s.fetch(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastAttempt = time.Now()
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	s.loaded = s.lastAttempt
+	return nil
+}
+
+// key returns the key with the given ID, or the only key if kid is empty.
+// The key set is reloaded in the background once it is older than its TTL,
+// and keeps verifying tokens meanwhile and until the source recovers. An
+// unknown key ID may have been rotated in, so the request waits for a
+// reload. Reloads are started at most once per TTL or minute, whichever is
+// shorter, so bogus IDs cannot hammer the source, and never run
+// concurrently.
+func (s *JWKS) key(ctx context.Context, kid string) (publicKey, error) {
+	s.mutex.Lock()
+	k, ok := s.lookup(kid)
+	if (!ok || time.Since(s.loaded) > s.ttl) && time.Since(s.lastAttempt) > min(s.ttl, time.Minute) {
+		s.refresh()
+	}
+	refreshing := s.refreshing
+	s.mutex.Unlock()
+
+	if ok {
+		return k, nil
+	}
+	if refreshing != nil {
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return publicKey{}, ctx.Err()
+		}
+		s.mutex.Lock()
+		k, ok = s.lookup(kid)
+		s.mutex.Unlock()
+		if ok {
+			return k, nil
+		}
+	}
+	return publicKey{}, fmt.Errorf("%w: unknown key ID %q", ErrInvalidCredential, kid)
+}
+
+func (s *JWKS) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// refresh starts reloading the key set in the background unless a reload
+// is in progress; the caller holds the mutex. The reload is not tied to the
+// request that started it, as other requests may wait for it.
+func (s *JWKS) refresh() {
+	if s.refreshing != nil {
+		return
+	}
+	done := make(chan struct{})
+	s.refreshing = done
+	s.lastAttempt = time.Now()
+
+	go func() {
+		defer close(done)
+		keys, err := s.fetch(context.Background())
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.refreshing = nil
+		if err != nil {
+			// Keep verifying with the previous keys until the source
+			// recovers.
+			logrus.WithError(err).Warn("Failed to refresh JWKS")
+			return
+		}
+		s.keys = keys
+		s.loaded = time.Now()
+	}()
+}
+
+// fetch loads and parses the key set.
+func (s *JWKS) fetch(ctx context.Context) (map[string]publicKey, error) {
+	data, err := s.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func parseJWKS(data []byte) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]publicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logrus.WithError(err).WithField("kid", k.Kid).Warn("Skipping unusable JWKS key")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS holds no usable signing key")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (publicKey, error) {
+	var alg string
+	var key crypto.PublicKey
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return publicKey{}, errors.New("RSA exponent too large")
+		}
+		alg, key = "RS256", &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return publicKey{}, errors.New("EC point is not on P-256")
+		}
+		alg, key = "ES256", &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("invalid Ed25519 key")
+		}
+		alg, key = "EdDSA", ed25519.PublicKey(x)
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key type %s %s", k.Kty, k.Crv)
+	}
+
+	if k.Alg != "" && k.Alg != alg {
+		return publicKey{}, fmt.Errorf("unsupported algorithm %s for %s key", k.Alg, k.Kty)
+	}
+	return publicKey{alg: alg, key: key}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
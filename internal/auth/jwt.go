@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig configures JWT bearer token validation.
+type JWTConfig struct {
+	JWKS     *JWKS
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+
+	// NameClaim names the principal; RolesClaim holds its roles, as a list
+	// or a space-separated string. Both may be dotted paths into nested
+	// claims, e.g. "realm_access.roles".
+	NameClaim  string
+	RolesClaim string
+
+	// RoleScopes grants scopes to roles.
+	RoleScopes map[string][]string
+}
+
+// JWTValidator authenticates RS256, ES256 and EdDSA signed JWTs.
+type JWTValidator struct {
+	cfg    JWTConfig
+	parser *jwt.Parser
+}
+
+func NewJWTValidator(cfg JWTConfig) *JWTValidator {
+	return &JWTValidator{
+		cfg: cfg,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(cfg.Leeway),
+		),
+	}
+}
+
+// Authenticate verifies the token's signature and its exp, nbf, iss and aud
+// claims. Credentials that are not JWTs are rejected with
+// ErrInvalidCredential.
+func (v *JWTValidator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if strings.Count(token, ".") != 2 {
+		return nil, ErrInvalidCredential
+	}
+
+	var claims jwt.MapClaims
+	_, err := v.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, err := v.cfg.JWKS.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if k.alg != t.Method.Alg() {
+			return nil, fmt.Errorf("key %q is not a %s key", kid, t.Method.Alg())
+		}
+		return k.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	nameClaim, _ := claim(claims, v.cfg.NameClaim)
+	name, _ := nameClaim.(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: token has no %s claim", ErrInvalidCredential, v.cfg.NameClaim)
+	}
+
+	rolesClaim, _ := claim(claims, v.cfg.RolesClaim)
+	roles := stringList(rolesClaim)
+	return &Principal{
+		Name:   name,
+		Roles:  roles,
+		Scopes: grantScopes(v.cfg.RoleScopes, roles),
+		Claims: claims,
+	}, nil
+}
+
+// grantScopes returns the scopes roleScopes grants to roles.
+func grantScopes(roleScopes map[string][]string, roles []string) []string {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, role := range roles {
+		for _, scope := range roleScopes[role] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// claim resolves a dotted path in claims. ok is false if the path does not
+// exist.
+func claim(claims map[string]interface{}, path string) (value interface{}, ok bool) {
+	value = claims
+	for _, name := range strings.Split(path, ".") {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ParseRoleScopes parses role to scope grants written as
+// "role=scope,scope;role=scope".
+func ParseRoleScopes(s string) (map[string][]string, error) {
+	grants := make(map[string][]string)
+	for _, grant := range strings.Split(s, ";") {
+		if strings.TrimSpace(grant) == "" {
+			continue
+		}
+		role, list, ok := strings.Cut(grant, "=")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid role grant %q, expected role=scope,scope", grant)
+		}
+		for _, scope := range strings.Split(list, ",") {
+			scope = strings.TrimSpace(scope)
+			if !knownScope(scope) {
+				return nil, fmt.Errorf("role %q has unknown scope %q", role, scope)
+			}
+			grants[role] = append(grants[role], scope)
+		}
+	}
+	return grants, nil
+}
+
+// Chain tries each authenticator in turn, returning the first principal.
+type Chain []Authenticator
+
+func (ch Chain) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	err := ErrInvalidCredential
+	for _, a := range ch {
+		p, e := a.Authenticate(ctx, credential)
+		if e == nil {
+			return p, nil
+		}
+		err = e
+	}
+	return nil, err
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keyFile is the format of the API key file. Keys are stored as the
+// hex-encoded SHA-256 of the key, prefixed with "sha256:".
+type keyFile struct {
+	Keys []struct {
+		Name   string                 `json:"name"`
+		Hash   string                 `json:"hash"`
+		Scopes []string               `json:"scopes"`
+		Roles  []string               `json:"roles"`
+		Claims map[string]interface{} `json:"claims"`
+	} `json:"keys"`
+}
+
+// KeyStore authenticates API keys against the hashes of a key file.
+type KeyStore struct {
+	principals map[[sha256.Size]byte]*Principal
+}
+
+// LoadKeys reads the key file at path.
+func LoadKeys(path string) (*KeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %w", err)
+	}
+
+	ks := &KeyStore{principals: make(map[[sha256.Size]byte]*Principal)}
+	names := make(map[string]bool)
+	for i, key := range file.Keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("API key %d has no name", i)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("API key %q is defined twice", key.Name)
+		}
+		names[key.Name] = true
+
+		hash, err := parseHash(key.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("API key %q: %w", key.Name, err)
+		}
+		if _, ok := ks.principals[hash]; ok {
+			return nil, fmt.Errorf("API key %q has the same hash as another key", key.Name)
+		}
+
+		for _, scope := range key.Scopes {
+			if !knownScope(scope) {
+				return nil, fmt.Errorf("API key %q has unknown scope %q", key.Name, scope)
+			}
+		}
+
+		ks.principals[hash] = &Principal{Name: key.Name, Scopes: key.Scopes, Roles: key.Roles, Claims: key.Claims}
+	}
+	return ks, nil
+}
+
+func parseHash(s string) (hash [sha256.Size]byte, err error) {
+	digest, ok := strings.CutPrefix(s, "sha256:")
+	if !ok {
+		return hash, fmt.Errorf(`hash must start with "sha256:"`)
+	}
+	b, err := hex.DecodeString(digest)
+	if err != nil || len(b) != sha256.Size {
+		return hash, fmt.Errorf("hash must be %d hex-encoded bytes", sha256.Size)
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// HashKey returns the key file representation of key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Authenticate looks the key up by its hash, so the comparison does not
+// depend on how much of a guessed key is correct.
+func (ks *KeyStore) Authenticate(_ context.Context, key string) (*Principal, error) {
+	if p, ok := ks.principals[sha256.Sum256([]byte(key))]; ok {
+		return p, nil
+	}
+	return nil, ErrInvalidCredential
+}
@@ -0,0 +1,161 @@
+// Package clientip resolves the address of the client behind trusted
+// reverse proxies.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Headers naming the client address.
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+	// HeaderForwarded is the RFC 7239 header, whose for= parameters name
+	// the client and each proxy.
+	HeaderForwarded = "Forwarded"
+)
+
+// Prefixes is a list of address ranges.
+type Prefixes []netip.Prefix
+
+// ParsePrefixes parses CIDR ranges and single addresses.
+func ParsePrefixes(entries []string) (Prefixes, error) {
+	prefixes := make(Prefixes, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Contains reports whether addr is in one of the ranges.
+func (p Prefixes) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolver finds the client address of requests, believing the forwarded
+// header only when it was set by a trusted proxy.
+type Resolver struct {
+	trusted Prefixes
+	header  string
+}
+
+// NewResolver returns a resolver reading header from requests sent by the
+// proxies in trusted. Without trusted proxies the header is ignored.
+func NewResolver(trusted Prefixes, header string) *Resolver {
+	return &Resolver{trusted: trusted, header: http.CanonicalHeaderKey(header)}
+}
+
+// Resolve returns the client address of a request sent from remoteAddr
+// with header h. Addresses in the forwarded header are read from the
+// right, the one added by the nearest proxy, to the first one not added by
+// a trusted proxy, since anything to its left may be forged by the client.
+// An entry that is not an address (such as "unknown") ends the walk at the
+// proxy that added it.
+func (r *Resolver) Resolve(remoteAddr string, h http.Header) (netip.Addr, bool) {
+	addr, ok := parseHostPort(remoteAddr)
+	if !ok || !r.trusted.Contains(addr) {
+		return addr.Unmap(), ok
+	}
+
+	hops := r.hops(h)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHostPort(hops[i])
+		if !ok {
+			break
+		}
+		addr = hop
+		if !r.trusted.Contains(addr) {
+			break
+		}
+	}
+	return addr.Unmap(), true
+}
+
+// hops returns the addresses in the forwarded header, client first.
+func (r *Resolver) hops(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values(r.header) {
+		switch r.header {
+		case HeaderForwarded:
+			hops = append(hops, forwardedFor(value)...)
+		case http.CanonicalHeaderKey(HeaderXRealIP):
+			hops = append(hops, strings.TrimSpace(value))
+		default:
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the for= parameter of each element of an RFC 7239
+// Forwarded header, or an empty string for elements without one.
+func forwardedFor(value string) []string {
+	var hops []string
+	for _, element := range splitQuoted(value, ',') {
+		hop := ""
+		for _, pair := range splitQuoted(element, ';') {
+			name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hop = strings.Trim(v, `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// splitQuoted splits s at sep outside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseHostPort parses an address with or without a port; IPv6 addresses
+// with a port are bracketed.
+func parseHostPort(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone(""), true
+}
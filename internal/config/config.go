@@ -2,9 +2,20 @@ package config
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/clientip"
+	"github.com/lab1702/goduck/internal/ratelimit"
+	"github.com/lab1702/goduck/internal/statements"
 )
 
 type Config struct {
@@ -14,17 +25,205 @@ type Config struct {
 	MaxConnections int
 	LogLevel       string
 	ReadWrite      bool
+
+	// Paged results are kept in memory for CursorTTL after their last read.
+	CursorTTL     time.Duration
+	MaxCursors    int
+	MaxCursorRows int
+	// MaxCursorTotalRows bounds the rows kept across all paged results.
+	MaxCursorTotalRows int
+
+	// Asynchronous jobs run on JobWorkers workers, may run for JobTimeout
+	// and keep their results for JobResultTTL.
+	JobWorkers   int
+	JobQueueSize int
+	MaxJobs      int
+	JobTimeout   time.Duration
+	JobResultTTL time.Duration
+
+	// MemoryLimit, Threads, TempDirectory and MaxTempDirectorySize bound
+	// the DuckDB instance; empty or zero values keep DuckDB's defaults.
+	MemoryLimit          string
+	Threads              int
+	TempDirectory        string
+	MaxTempDirectorySize string
+
+	// AllowedStatements is a comma-separated list of statement classes that
+	// may be run, or "all".
+	AllowedStatements string
+
+	// Sandbox blocks file and network access from queries, except to
+	// AllowedDirectories and AllowedPaths. It is on by default for
+	// read-only deployments.
+	Sandbox            bool
+	AllowedDirectories []string
+	AllowedPaths       []string
+
+	// APIKeysFile enables authentication with the hashed API keys it lists.
+	APIKeysFile string
+
+	// AccessPolicyFile restricts the tables and columns principals may read.
+	AccessPolicyFile string
+
+	// JWTs are accepted when a JWKS file or URL is set. Their roles, read
+	// from JWTRolesClaim, are granted scopes by JWTRoleScopes.
+	JWKSFile      string
+	JWKSURL       string
+	JWKSRefresh   time.Duration
+	JWTIssuer     string
+	JWTAudience   string
+	JWTLeeway     time.Duration
+	JWTNameClaim  string
+	JWTRolesClaim string
+	JWTRoleScopes map[string][]string
+
+	// AuditLogFile, if set, receives an audit record of every statement.
+	// It is rotated at AuditMaxSizeMB, keeping AuditMaxFiles old files.
+	AuditLogFile   string
+	AuditMaxSizeMB int
+	AuditMaxFiles  int
+
+	// TLS is served when a certificate and key are set; they are reloaded
+	// when they change. Client certificates are verified against
+	// TLSClientCAFile, if set, and their organizational units are granted
+	// scopes by TLSClientRoleScopes.
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSMinVersion       string
+	TLSClientCAFile     string
+	TLSClientAuth       string
+	TLSClientRoleScopes map[string][]string
+	TLSReloadInterval   time.Duration
+
+	// TracingExporter selects where OpenTelemetry spans are sent: none,
+	// otlp, stdout or file (TracingFile). TracingSampleRatio of new traces
+	// are sampled.
+	TracingExporter    string
+	TracingFile        string
+	TracingSampleRatio float64
+
+	// RateLimit applies to each principal, or client IP when
+	// unauthenticated, on every route but those in RateLimitRoutes.
+	RateLimit       ratelimit.Limit
+	RateLimitRoutes map[string]ratelimit.Limit
+
+	// ClientIPHeader names the client in requests from TrustedProxies.
+	// Clients in IPDeny, or outside IPAllow if it is set, are refused.
+	TrustedProxies clientip.Prefixes
+	ClientIPHeader string
+	IPAllow        clientip.Prefixes
+	IPDeny         clientip.Prefixes
+
+	// MaxConcurrentQueries queries run at once; up to QueryQueueSize more
+	// wait up to QueryQueueTimeout, BatchPrincipals' behind the others.
+	// Zero MaxConcurrentQueries disables admission control.
+	// RoleQueryLimits caps the queries of principals by role.
+	MaxConcurrentQueries int
+	QueryQueueSize       int
+	QueryQueueTimeout    time.Duration
+	BatchPrincipals      []string
+	RoleQueryLimits      map[string]admission.RoleLimit
 }
 
 func Load() (*Config, error) {
+	readWrite := getBoolEnv("GODUCK_READ_WRITE", false)
+
 	cfg := &Config{
-		DatabasePath:   os.Getenv("GODUCK_DATABASE_PATH"),
-		Port:           getEnv("GODUCK_PORT", "8080"),
-		QueryTimeout:   getDurationEnv("GODUCK_QUERY_TIMEOUT", 30*time.Second),
-		MaxConnections: getIntEnv("GODUCK_MAX_CONNECTIONS", 10),
-		LogLevel:       getEnv("GODUCK_LOG_LEVEL", "info"),
-		ReadWrite:      getBoolEnv("GODUCK_READ_WRITE", false),
+		DatabasePath:       os.Getenv("GODUCK_DATABASE_PATH"),
+		Port:               getEnv("GODUCK_PORT", "8080"),
+		QueryTimeout:       getDurationEnv("GODUCK_QUERY_TIMEOUT", 30*time.Second),
+		MaxConnections:     getIntEnv("GODUCK_MAX_CONNECTIONS", 10),
+		LogLevel:           getEnv("GODUCK_LOG_LEVEL", "info"),
+		ReadWrite:          readWrite,
+		CursorTTL:          getDurationEnv("GODUCK_CURSOR_TTL", 5*time.Minute),
+		MaxCursors:         getIntEnv("GODUCK_MAX_CURSORS", 100),
+		MaxCursorRows:      getIntEnv("GODUCK_MAX_CURSOR_ROWS", 100000),
+		MaxCursorTotalRows: getIntEnv("GODUCK_MAX_CURSOR_TOTAL_ROWS", 1000000),
+		JobWorkers:         getIntEnv("GODUCK_JOB_WORKERS", 2),
+		JobQueueSize:       getIntEnv("GODUCK_JOB_QUEUE_SIZE", 100),
+		MaxJobs:            getIntEnv("GODUCK_MAX_JOBS", 1000),
+		JobTimeout:         getDurationEnv("GODUCK_JOB_TIMEOUT", time.Hour),
+		JobResultTTL:       getDurationEnv("GODUCK_JOB_RESULT_TTL", time.Hour),
+
+		MemoryLimit:          os.Getenv("GODUCK_MEMORY_LIMIT"),
+		Threads:              getIntEnv("GODUCK_THREADS", 0),
+		TempDirectory:        os.Getenv("GODUCK_TEMP_DIRECTORY"),
+		MaxTempDirectorySize: os.Getenv("GODUCK_MAX_TEMP_DIRECTORY_SIZE"),
+
+		AllowedStatements: getEnv("GODUCK_ALLOWED_STATEMENTS", "select,explain"),
+
+		Sandbox:            getBoolEnv("GODUCK_SANDBOX", !readWrite),
+		AllowedDirectories: getListEnv("GODUCK_ALLOWED_DIRECTORIES"),
+		AllowedPaths:       getListEnv("GODUCK_ALLOWED_PATHS"),
+
+		APIKeysFile:      os.Getenv("GODUCK_API_KEYS_FILE"),
+		AccessPolicyFile: os.Getenv("GODUCK_ACCESS_POLICY_FILE"),
+
+		JWKSFile:      os.Getenv("GODUCK_JWKS_FILE"),
+		JWKSURL:       os.Getenv("GODUCK_JWKS_URL"),
+		JWKSRefresh:   getDurationEnv("GODUCK_JWKS_REFRESH", 10*time.Minute),
+		JWTIssuer:     os.Getenv("GODUCK_JWT_ISSUER"),
+		JWTAudience:   os.Getenv("GODUCK_JWT_AUDIENCE"),
+		JWTLeeway:     getDurationEnv("GODUCK_JWT_LEEWAY", 30*time.Second),
+		JWTNameClaim:  getEnv("GODUCK_JWT_NAME_CLAIM", "sub"),
+		JWTRolesClaim: getEnv("GODUCK_JWT_ROLES_CLAIM", "roles"),
+
+		AuditLogFile:   os.Getenv("GODUCK_AUDIT_LOG_FILE"),
+		AuditMaxSizeMB: getIntEnv("GODUCK_AUDIT_MAX_SIZE_MB", 100),
+		AuditMaxFiles:  getIntEnv("GODUCK_AUDIT_MAX_FILES", 10),
+
+		TLSCertFile:       os.Getenv("GODUCK_TLS_CERT_FILE"),
+		TLSKeyFile:        os.Getenv("GODUCK_TLS_KEY_FILE"),
+		TLSMinVersion:     getEnv("GODUCK_TLS_MIN_VERSION", "1.2"),
+		TLSClientCAFile:   os.Getenv("GODUCK_TLS_CLIENT_CA_FILE"),
+		TLSClientAuth:     getEnv("GODUCK_TLS_CLIENT_AUTH", "require"),
+		TLSReloadInterval: getDurationEnv("GODUCK_TLS_RELOAD_INTERVAL", 10*time.Second),
+
+		QueryQueueSize:    getIntEnv("GODUCK_QUERY_QUEUE_SIZE", 100),
+		QueryQueueTimeout: getDurationEnv("GODUCK_QUERY_QUEUE_TIMEOUT", 10*time.Second),
+		BatchPrincipals:   getListEnv("GODUCK_BATCH_PRINCIPALS"),
+
+		ClientIPHeader: getEnv("GODUCK_CLIENT_IP_HEADER", clientip.HeaderXForwardedFor),
+
+		TracingExporter:    getEnv("GODUCK_TRACING_EXPORTER", "none"),
+		TracingFile:        os.Getenv("GODUCK_TRACING_FILE"),
+		TracingSampleRatio: getFloatEnv("GODUCK_TRACING_SAMPLE_RATIO", 1),
+	}
+
+	cfg.MaxConcurrentQueries = getIntEnv("GODUCK_MAX_CONCURRENT_QUERIES", cfg.MaxConnections)
+	cfg.RateLimit.PerMinute = getIntEnv("GODUCK_RATE_LIMIT", 60)
+	cfg.RateLimit.Burst = getIntEnv("GODUCK_RATE_LIMIT_BURST", cfg.RateLimit.PerMinute)
+	routeLimits, err := ratelimit.ParseRoutes(getListEnv("GODUCK_RATE_LIMIT_ROUTES"))
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_ROUTES is invalid: %v", err)
 	}
+	cfg.RateLimitRoutes = routeLimits
+
+	if cfg.TrustedProxies, err = clientip.ParsePrefixes(getListEnv("GODUCK_TRUSTED_PROXIES")); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES is invalid: %v", err)
+	}
+	if cfg.IPAllow, err = clientip.ParsePrefixes(getListEnv("GODUCK_IP_ALLOW")); err != nil {
+		return nil, fmt.Errorf("IP_ALLOW is invalid: %v", err)
+	}
+	if cfg.IPDeny, err = clientip.ParsePrefixes(getListEnv("GODUCK_IP_DENY")); err != nil {
+		return nil, fmt.Errorf("IP_DENY is invalid: %v", err)
+	}
+
+	if cfg.RoleQueryLimits, err = admission.ParseRoleLimits(os.Getenv("GODUCK_ROLE_QUERY_LIMITS")); err != nil {
+		return nil, fmt.Errorf("ROLE_QUERY_LIMITS is invalid: %v", err)
+	}
+
+	roleScopes, err := auth.ParseRoleScopes(os.Getenv("GODUCK_JWT_ROLE_SCOPES"))
+	if err != nil {
+		return nil, fmt.Errorf("JWT_ROLE_SCOPES is invalid: %v", err)
+	}
+	cfg.JWTRoleScopes = roleScopes
+
+	clientRoleScopes, err := auth.ParseRoleScopes(os.Getenv("GODUCK_TLS_CLIENT_ROLE_SCOPES"))
+	if err != nil {
+		return nil, fmt.Errorf("TLS_CLIENT_ROLE_SCOPES is invalid: %v", err)
+	}
+	cfg.TLSClientRoleScopes = clientRoleScopes
 
 	return cfg, cfg.Validate()
 }
@@ -40,9 +239,170 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("QUERY_TIMEOUT must be between 1s and 10m, got %v", c.QueryTimeout)
 	}
 
+	if c.CursorTTL < time.Second || c.CursorTTL > 24*time.Hour {
+		return fmt.Errorf("CURSOR_TTL must be between 1s and 24h, got %v", c.CursorTTL)
+	}
+
+	if c.MaxCursors < 1 {
+		return fmt.Errorf("MAX_CURSORS must be at least 1, got %d", c.MaxCursors)
+	}
+
+	if c.MaxCursorRows < 1 {
+		return fmt.Errorf("MAX_CURSOR_ROWS must be at least 1, got %d", c.MaxCursorRows)
+	}
+
+	if c.MaxCursorTotalRows < c.MaxCursorRows {
+		return fmt.Errorf("MAX_CURSOR_TOTAL_ROWS must be at least MAX_CURSOR_ROWS (%d), got %d", c.MaxCursorRows, c.MaxCursorTotalRows)
+	}
+
+	if c.JobWorkers < 1 || c.JobWorkers > 64 {
+		return fmt.Errorf("JOB_WORKERS must be between 1 and 64, got %d", c.JobWorkers)
+	}
+
+	if c.JobQueueSize < 1 {
+		return fmt.Errorf("JOB_QUEUE_SIZE must be at least 1, got %d", c.JobQueueSize)
+	}
+
+	if c.MaxJobs < c.JobQueueSize {
+		return fmt.Errorf("MAX_JOBS must be at least JOB_QUEUE_SIZE (%d), got %d", c.JobQueueSize, c.MaxJobs)
+	}
+
+	if c.JobTimeout < time.Second || c.JobTimeout > 24*time.Hour {
+		return fmt.Errorf("JOB_TIMEOUT must be between 1s and 24h, got %v", c.JobTimeout)
+	}
+
+	if c.JobResultTTL < time.Second || c.JobResultTTL > 7*24*time.Hour {
+		return fmt.Errorf("JOB_RESULT_TTL must be between 1s and 168h, got %v", c.JobResultTTL)
+	}
+
+	if c.MemoryLimit != "" && !validSize(c.MemoryLimit) {
+		return fmt.Errorf("MEMORY_LIMIT must be a size such as 4GB, got %q", c.MemoryLimit)
+	}
+
+	if c.Threads < 0 || c.Threads > 1024 {
+		return fmt.Errorf("THREADS must be between 0 and 1024, got %d", c.Threads)
+	}
+
+	if c.MaxTempDirectorySize != "" && !validSize(c.MaxTempDirectorySize) {
+		return fmt.Errorf("MAX_TEMP_DIRECTORY_SIZE must be a size such as 100GB, got %q", c.MaxTempDirectorySize)
+	}
+
+	if _, err := statements.ParseClasses(c.AllowedStatements); err != nil {
+		return fmt.Errorf("ALLOWED_STATEMENTS is invalid: %v", err)
+	}
+
+	if !c.Sandbox && (len(c.AllowedDirectories) > 0 || len(c.AllowedPaths) > 0) {
+		return fmt.Errorf("ALLOWED_DIRECTORIES and ALLOWED_PATHS require SANDBOX=true")
+	}
+
+	if c.JWKSFile != "" && c.JWKSURL != "" {
+		return fmt.Errorf("set only one of JWKS_FILE and JWKS_URL")
+	}
+
+	if c.JWTEnabled() {
+		if c.JWTIssuer == "" || c.JWTAudience == "" {
+			return fmt.Errorf("JWT_ISSUER and JWT_AUDIENCE are required with a JWKS")
+		}
+		if c.JWKSRefresh < time.Second || c.JWKSRefresh > 24*time.Hour {
+			return fmt.Errorf("JWKS_REFRESH must be between 1s and 24h, got %v", c.JWKSRefresh)
+		}
+		if c.JWTLeeway < 0 || c.JWTLeeway > 5*time.Minute {
+			return fmt.Errorf("JWT_LEEWAY must be between 0 and 5m, got %v", c.JWTLeeway)
+		}
+	}
+
+	if c.JWKSURL != "" {
+		if u, err := url.Parse(c.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("JWKS_URL must be an http(s) URL, got %q", c.JWKSURL)
+		}
+	}
+
+	if c.AuditLogFile != "" {
+		if c.AuditMaxSizeMB < 1 || c.AuditMaxSizeMB > 10240 {
+			return fmt.Errorf("AUDIT_MAX_SIZE_MB must be between 1 and 10240, got %d", c.AuditMaxSizeMB)
+		}
+		if c.AuditMaxFiles < 1 || c.AuditMaxFiles > 1000 {
+			return fmt.Errorf("AUDIT_MAX_FILES must be between 1 and 1000, got %d", c.AuditMaxFiles)
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if c.TLSEnabled() {
+		if c.TLSMinVersion != "1.2" && c.TLSMinVersion != "1.3" {
+			return fmt.Errorf("TLS_MIN_VERSION must be 1.2 or 1.3, got %q", c.TLSMinVersion)
+		}
+		if c.TLSClientAuth != "require" && c.TLSClientAuth != "optional" {
+			return fmt.Errorf("TLS_CLIENT_AUTH must be require or optional, got %q", c.TLSClientAuth)
+		}
+		if c.TLSReloadInterval < time.Second || c.TLSReloadInterval > time.Hour {
+			return fmt.Errorf("TLS_RELOAD_INTERVAL must be between 1s and 1h, got %v", c.TLSReloadInterval)
+		}
+	} else if c.TLSClientCAFile != "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	switch c.TracingExporter {
+	case "none", "otlp", "stdout":
+	case "file":
+		if c.TracingFile == "" {
+			return fmt.Errorf("TRACING_FILE must be set for the file exporter")
+		}
+	default:
+		return fmt.Errorf("TRACING_EXPORTER must be none, otlp, stdout or file, got %q", c.TracingExporter)
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		return fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.TracingSampleRatio)
+	}
+
+	switch http.CanonicalHeaderKey(c.ClientIPHeader) {
+	case clientip.HeaderXForwardedFor, http.CanonicalHeaderKey(clientip.HeaderXRealIP), clientip.HeaderForwarded:
+	default:
+		return fmt.Errorf("CLIENT_IP_HEADER must be X-Forwarded-For, X-Real-IP or Forwarded, got %q", c.ClientIPHeader)
+	}
+
+	if c.MaxConcurrentQueries < 0 || c.MaxConcurrentQueries > 10000 {
+		return fmt.Errorf("MAX_CONCURRENT_QUERIES must be between 0 and 10000, got %d", c.MaxConcurrentQueries)
+	}
+	if c.MaxConcurrentQueries > 0 {
+		if c.QueryQueueSize < 0 || c.QueryQueueSize > 100000 {
+			return fmt.Errorf("QUERY_QUEUE_SIZE must be between 0 and 100000, got %d", c.QueryQueueSize)
+		}
+		if c.QueryQueueTimeout < time.Millisecond || c.QueryQueueTimeout > 10*time.Minute {
+			return fmt.Errorf("QUERY_QUEUE_TIMEOUT must be between 1ms and 10m, got %v", c.QueryQueueTimeout)
+		}
+	} else if len(c.RoleQueryLimits) > 0 {
+		return fmt.Errorf("ROLE_QUERY_LIMITS requires MAX_CONCURRENT_QUERIES above 0")
+	}
+
+	if c.RateLimit.PerMinute < 0 || c.RateLimit.PerMinute > 1000000 {
+		return fmt.Errorf("RATE_LIMIT must be between 0 and 1000000, got %d", c.RateLimit.PerMinute)
+	}
+	if !c.RateLimit.Unlimited() && (c.RateLimit.Burst < 1 || c.RateLimit.Burst > 1000000) {
+		return fmt.Errorf("RATE_LIMIT_BURST must be between 1 and 1000000, got %d", c.RateLimit.Burst)
+	}
+
+	for _, path := range append(append([]string{}, c.AllowedDirectories...), c.AllowedPaths...) {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("allowed directories and paths must be absolute, got %q", path)
+		}
+	}
+
 	return nil
 }
 
+// JWTEnabled reports whether JWT bearer tokens are accepted.
+func (c *Config) JWTEnabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// TLSEnabled reports whether the server is served over TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -50,6 +410,17 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getListEnv splits a comma-separated variable, dropping empty entries.
+func getListEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -68,6 +439,15 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -76,3 +456,10 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// sizePattern matches the sizes DuckDB accepts for memory and disk limits.
+var sizePattern = regexp.MustCompile(`(?i)^[0-9]+(\.[0-9]+)?\s*(b|[kmgt]i?b)$`)
+
+func validSize(s string) bool {
+	return sizePattern.MatchString(strings.TrimSpace(s))
+}
@@ -0,0 +1,190 @@
+package cursor
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+// ErrInvalidToken is returned by ParseToken for tokens this server did not issue.
+var ErrInvalidToken = errors.New("invalid page token")
+
+// Result is a materialized query result kept for later pages. SQL is the
+// query as the client sent it and Principal the name of the principal that
+// ran it, empty if authentication is disabled.
+type Result struct {
+	SQL       string
+	Principal string
+	Columns   []string
+	Schema    []models.ColumnSchema
+	Rows      [][]interface{}
+	Limit     int
+
+	expires time.Time
+}
+
+// Page returns up to limit rows starting at offset and the offset of the
+// following page, or -1 if there is none.
+func (r *Result) Page(offset, limit int) ([][]interface{}, int) {
+	if offset >= len(r.Rows) {
+		return [][]interface{}{}, -1
+	}
+	end := offset + limit
+	if end >= len(r.Rows) {
+		return r.Rows[offset:], -1
+	}
+	return r.Rows[offset:end], end
+}
+
+// Store keeps materialized results in memory until their TTL expires. When
+// the store is full, by number of results or by rows across all of them,
+// the results closest to expiry are evicted.
+type Store struct {
+	results      map[string]*Result
+	mutex        sync.Mutex
+	ttl          time.Duration
+	maxResults   int
+	maxRows      int
+	maxTotalRows int
+	// rows counts the rows of all stored results.
+	rows int
+
+	stop chan struct{}
+}
+
+func NewStore(ttl time.Duration, maxResults, maxRows, maxTotalRows int) *Store {
+	s := &Store{
+		results:      make(map[string]*Result),
+		ttl:          ttl,
+		maxResults:   maxResults,
+		maxRows:      maxRows,
+		maxTotalRows: maxTotalRows,
+		stop:         make(chan struct{}),
+	}
+
+	// Cleanup routine
+	go s.cleanupExpired()
+	return s
+}
+
+// Close stops the cleanup routine and drops every stored result.
+func (s *Store) Close() {
+	close(s.stop)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results = make(map[string]*Result)
+	s.rows = 0
+}
+
+// TTL returns how long a result is kept after it was last read.
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// MaxRows returns the largest result that can be stored.
+func (s *Store) MaxRows() int {
+	return s.maxRows
+}
+
+// Put stores a result and returns its ID.
+func (s *Store) Put(r *Result) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for len(s.results) > 0 && (len(s.results) >= s.maxResults || s.rows+len(r.Rows) > s.maxTotalRows) {
+		s.evictOldest()
+	}
+	r.expires = time.Now().Add(s.ttl)
+	s.results[id] = r
+	s.rows += len(r.Rows)
+	return id, nil
+}
+
+// Get returns the result with the given ID and extends its TTL.
+func (s *Store) Get(id string) (*Result, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, ok := s.results[id]
+	if !ok || time.Now().After(r.expires) {
+		return nil, false
+	}
+	r.expires = time.Now().Add(s.ttl)
+	return r, true
+}
+
+func (s *Store) evictOldest() {
+	var oldest string
+	for id, r := range s.results {
+		if oldest == "" || r.expires.Before(s.results[oldest].expires) {
+			oldest = id
+		}
+	}
+	s.remove(oldest)
+}
+
+// remove deletes a result. It is called with the mutex held.
+func (s *Store) remove(id string) {
+	s.rows -= len(s.results[id].Rows)
+	delete(s.results, id)
+}
+
+func (s *Store) cleanupExpired() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(time.Minute):
+		}
+
+		now := time.Now()
+		s.mutex.Lock()
+		for id, r := range s.results {
+			if now.After(r.expires) {
+				s.remove(id)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// Token identifies a page of a stored result.
+type Token struct {
+	ID     string
+	Offset int
+}
+
+// String encodes the token as an opaque URL-safe string.
+func (t Token) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.ID + ":" + strconv.Itoa(t.Offset)))
+}
+
+func ParseToken(s string) (Token, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	id, offset, ok := strings.Cut(string(b), ":")
+	if !ok || id == "" {
+		return Token{}, ErrInvalidToken
+	}
+	n, err := strconv.Atoi(offset)
+	if err != nil || n < 0 {
+		return Token{}, ErrInvalidToken
+	}
+	return Token{ID: id, Offset: n}, nil
+}
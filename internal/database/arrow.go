@@ -0,0 +1,74 @@
+//go:build duckdb_arrow
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// QueryArrow runs query through DuckDB's Arrow interface. The connection stays
+// reserved until the returned release function is called.
+func (db *DB) QueryArrow(ctx context.Context, query string, args ...interface{}) (array.RecordReader, func(), error) {
+	if err := checkArrowArgs(args); err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := queryArrow(ctx, conn, query, args)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	release := func() {
+		reader.Release()
+		conn.Close()
+	}
+	return reader, release, nil
+}
+
+// QueryArrow runs query on the dedicated connection through DuckDB's Arrow
+// interface. The returned release function releases the reader only.
+func (c *Conn) QueryArrow(ctx context.Context, query string, args ...interface{}) (array.RecordReader, func(), error) {
+	if err := checkArrowArgs(args); err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := queryArrow(ctx, c.Conn, query, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, reader.Release, nil
+}
+
+func checkArrowArgs(args []interface{}) error {
+	for _, arg := range args {
+		if _, ok := arg.(sql.NamedArg); ok {
+			return fmt.Errorf("%w: named parameters are not supported", ErrArrowUnavailable)
+		}
+	}
+	return nil
+}
+
+func queryArrow(ctx context.Context, conn *sql.Conn, query string, args []interface{}) (array.RecordReader, error) {
+	var reader array.RecordReader
+	err := conn.Raw(func(driverConn interface{}) error {
+		a, err := duckdb.NewArrowFromConn(driverConn.(driver.Conn))
+		if err != nil {
+			return err
+		}
+		reader, err = a.QueryContext(ctx, query, args...)
+		return err
+	})
+	return reader, err
+}
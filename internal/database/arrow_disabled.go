@@ -0,0 +1,21 @@
+//go:build !duckdb_arrow
+
+package database
+
+import (
+	"context"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// QueryArrow is unavailable unless the server is built with the duckdb_arrow
+// build tag, which enables go-duckdb's Arrow interface.
+func (db *DB) QueryArrow(ctx context.Context, query string, args ...interface{}) (array.RecordReader, func(), error) {
+	return nil, nil, ErrArrowUnavailable
+}
+
+// QueryArrow is unavailable unless the server is built with the duckdb_arrow
+// build tag.
+func (c *Conn) QueryArrow(ctx context.Context, query string, args ...interface{}) (array.RecordReader, func(), error) {
+	return nil, nil, ErrArrowUnavailable
+}
@@ -1,19 +1,114 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/marcboeker/go-duckdb/v2"
+	"github.com/marcboeker/go-duckdb/v2"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrArrowUnavailable is returned by QueryArrow when Arrow output cannot be
+// produced, either because of the build or because of the query's arguments.
+var ErrArrowUnavailable = errors.New("arrow output unavailable")
+
 type DB struct {
 	conn *sql.DB
+
+	// dedicated hands out connections that live outside the size-limited
+	// pool, for work that must keep per-connection state such as temporary
+	// tables. It shares the DuckDB instance with conn.
+	dedicated *sql.DB
+}
+
+// Conn is a connection reserved for a single caller until Close.
+type Conn struct {
+	*sql.Conn
+}
+
+// sharedConnector lets a second pool use the same DuckDB instance without
+// closing it; the instance is closed with the main pool.
+type sharedConnector struct {
+	driver.Connector
+}
+
+// Sandbox restricts what queries can reach outside the database. With a
+// sandbox, DuckDB refuses all file and network access except to the allowed
+// directories and paths, extensions are not loaded automatically, and the
+// configuration is locked so that queries cannot lift the restrictions.
+type Sandbox struct {
+	AllowedDirectories []string
+	AllowedPaths       []string
+}
+
+// settings returns the statements applying the sandbox, in order: the
+// allowed roots can only be changed while external access is enabled, and
+// nothing can be changed once the configuration is locked.
+func (s *Sandbox) settings() []string {
+	var settings []string
+	if len(s.AllowedDirectories) > 0 {
+		settings = append(settings, "SET GLOBAL allowed_directories = "+stringList(s.AllowedDirectories))
+	}
+	if len(s.AllowedPaths) > 0 {
+		settings = append(settings, "SET GLOBAL allowed_paths = "+stringList(s.AllowedPaths))
+	}
+	return append(settings,
+		"SET GLOBAL enable_external_access = false",
+		"SET GLOBAL lock_configuration = true",
+	)
+}
+
+// Resources bounds the memory, threads and temporary disk space of the
+// DuckDB instance; zero fields keep DuckDB's defaults. DuckDB only has these
+// settings for the whole instance, so they cannot be set per connection or
+// per query.
+type Resources struct {
+	MemoryLimit          string
+	Threads              int
+	TempDirectory        string
+	MaxTempDirectorySize string
+}
+
+// settings returns the statements applying the limits.
+func (r *Resources) settings() []string {
+	var settings []string
+	if r.MemoryLimit != "" {
+		settings = append(settings, "SET GLOBAL memory_limit = "+stringLiteral(r.MemoryLimit))
+	}
+	if r.Threads > 0 {
+		settings = append(settings, fmt.Sprintf("SET GLOBAL threads = %d", r.Threads))
+	}
+	if r.TempDirectory != "" {
+		settings = append(settings, "SET GLOBAL temp_directory = "+stringLiteral(r.TempDirectory))
+	}
+	if r.MaxTempDirectorySize != "" {
+		settings = append(settings, "SET GLOBAL max_temp_directory_size = "+stringLiteral(r.MaxTempDirectorySize))
+	}
+	return settings
+}
+
+// stringLiteral formats value as a DuckDB string literal.
+func stringLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// stringList formats values as a DuckDB list literal.
+func stringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = stringLiteral(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
 }
 
-func NewDB(dbPath string, maxConnections int, readWrite bool) (*DB, error) {
+// NewDB opens the database. resources may be nil to keep DuckDB's defaults
+// and sandbox nil to allow unrestricted access.
+func NewDB(dbPath string, maxConnections int, readWrite bool, resources *Resources, sandbox *Sandbox) (*DB, error) {
 	// Validate configuration
 	if dbPath == "" && !readWrite {
 		return nil, fmt.Errorf("in-memory database requires read-write access (set GODUCK_READ_WRITE=true)")
@@ -37,10 +132,15 @@ func NewDB(dbPath string, maxConnections int, readWrite bool) (*DB, error) {
 		dsn = fmt.Sprintf("%s?access_mode=%s", dbPath, accessMode)
 	}
 
-	conn, err := sql.Open("duckdb", dsn)
+	if sandbox != nil {
+		dsn += "&autoload_known_extensions=false&autoinstall_known_extensions=false"
+	}
+
+	connector, err := duckdb.NewConnector(dsn, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	conn := sql.OpenDB(connector)
 
 	conn.SetMaxOpenConns(maxConnections)
 	conn.SetMaxIdleConns(maxConnections / 2)
@@ -51,19 +151,122 @@ func NewDB(dbPath string, maxConnections int, readWrite bool) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// The settings are global, so applying them once covers every
+	// connection to the instance. The resource limits come first, since
+	// the sandbox locks the configuration.
+	if resources != nil {
+		for _, setting := range resources.settings() {
+			if _, err := conn.Exec(setting); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("failed to apply resource limits: %w", err)
+			}
+		}
+	}
+	if sandbox != nil {
+		for _, setting := range sandbox.settings() {
+			if _, err := conn.Exec(setting); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("failed to apply sandbox: %w", err)
+			}
+		}
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"database_path":   dbPath,
 		"access_mode":     accessMode,
 		"max_connections": maxConnections,
+		"sandbox":         sandbox != nil,
 	}).Info("Database connection established")
 
-	return &DB{conn: conn}, nil
+	// Dedicated connections are closed as soon as they are released, so
+	// their session state never leaks to another caller.
+	dedicated := sql.OpenDB(sharedConnector{connector})
+	dedicated.SetMaxIdleConns(0)
+
+	return &DB{conn: conn, dedicated: dedicated}, nil
 }
 
 func (db *DB) Close() error {
+	db.dedicated.Close()
 	return db.conn.Close()
 }
 
+// QueryContext runs a query on a pooled connection.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.conn.QueryContext(ctx, query, args...)
+}
+
+// StatementType prepares query without executing it and returns its DuckDB
+// statement type. query must hold a single statement: the driver executes
+// all but the last statement of a multi-statement query while preparing it.
+func (db *DB) StatementType(ctx context.Context, query string) (duckdb.StmtType, error) {
+	stmtType := duckdb.STATEMENT_TYPE_INVALID
+	err := db.prepare(ctx, query, func(stmt *duckdb.Stmt) error {
+		var err error
+		stmtType, err = stmt.StatementType()
+		return err
+	})
+	return stmtType, err
+}
+
+// UntypedParams prepares query without executing it and returns the names of
+// the parameters whose type DuckDB takes from the bound value, e.g. in
+// "id = ANY(?)", rather than from the query. Positional parameters are named
+// by their position. query must hold a single statement, as for
+// StatementType.
+func (db *DB) UntypedParams(ctx context.Context, query string) (map[string]bool, error) {
+	untyped := make(map[string]bool)
+	err := db.prepare(ctx, query, func(stmt *duckdb.Stmt) error {
+		for i := 1; i <= stmt.NumInput(); i++ {
+			t, err := stmt.ParamType(i)
+			if err != nil {
+				return err
+			}
+			if t != duckdb.TYPE_INVALID {
+				continue
+			}
+			name, err := stmt.ParamName(i)
+			if err != nil {
+				return err
+			}
+			untyped[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return untyped, nil
+}
+
+func (db *DB) prepare(ctx context.Context, query string, fn func(*duckdb.Stmt) error) error {
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stmt, err := driverConn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		return fn(stmt.(*duckdb.Stmt))
+	})
+}
+
+// DedicatedConn returns a connection outside the main pool. Its session
+// state, including temporary tables, is discarded when it is closed.
+func (db *DB) DedicatedConn(ctx context.Context) (*Conn, error) {
+	conn, err := db.dedicated.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn}, nil
+}
+
 func (db *DB) GetConnection() *sql.DB {
 	return db.conn
 }
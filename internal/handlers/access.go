@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lab1702/goduck/internal/access"
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// applyAccess rejects sql if it reads a table or column the principal may
+// not read and otherwise returns the query to run in its place, which reads
+// only the rows the principal's row filters let through, with its masked
+// columns masked. It writes the error response and returns false if the
+// query must not run.
+func (h *QueryHandler) applyAccess(c *gin.Context, sql string) (string, bool) {
+	if h.access == nil {
+		return sql, true
+	}
+
+	requestID, _ := c.Get("request_id")
+	entry := logrus.WithFields(logrus.Fields{
+		"request_id": requestID,
+		"principal":  principalName(c),
+		"sql":        sql,
+	})
+
+	// Planning and rewriting run queries of their own on the pool.
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.queryTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "query.access")
+	query, err := h.access.Apply(ctx, auth.PrincipalFrom(c), sql)
+	tracing.End(span, err)
+	var denied *access.DeniedError
+	var policy *access.PolicyError
+	switch {
+	case err == nil:
+		return query, true
+	case errors.As(err, &denied), errors.As(err, &policy), errors.Is(err, access.ErrUnverifiable):
+		entry.WithError(err).Warn("Access denied")
+		audit.EntryFrom(c).Fail(audit.OutcomeDenied, err)
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error: err.Error(),
+			Code:  models.CodeAccessDenied,
+			Time:  time.Now(),
+		})
+	default:
+		entry.WithError(err).Error("Query execution failed")
+		audit.EntryFrom(c).Fail(audit.OutcomeFailed, err)
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "Query execution failed",
+			Time:  time.Now(),
+		})
+	}
+	return "", false
+}
+
+// auditTables records the tables sql reads in the request's audit record.
+// Statements DuckDB cannot plan, such as DDL, are recorded without tables.
+func (h *QueryHandler) auditTables(c *gin.Context, sql string) {
+	entry := audit.EntryFrom(c)
+	if !entry.Logged() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.queryTimeout)
+	defer cancel()
+	refs, err := access.References(ctx, h.db, sql)
+	if err != nil {
+		return
+	}
+	tables := make([]string, len(refs))
+	for i, ref := range refs {
+		tables[i] = ref.String()
+	}
+	entry.Tables(tables)
+}
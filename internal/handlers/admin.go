@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminHandler serves operator endpoints under /admin.
+type AdminHandler struct {
+	running *registry.Registry
+	audit   *audit.Log
+}
+
+// NewAdminHandler returns the admin handler. auditLog may be nil if
+// auditing is disabled.
+func NewAdminHandler(running *registry.Registry, auditLog *audit.Log) *AdminHandler {
+	return &AdminHandler{running: running, audit: auditLog}
+}
+
+// ListQueries lists the queries currently running, oldest first.
+func (h *AdminHandler) ListQueries(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"queries": h.running.List(),
+	})
+}
+
+// CancelQuery cancels the running query with the given request ID. The
+// query's own request then fails with a "Query cancelled" error.
+func (h *AdminHandler) CancelQuery(c *gin.Context) {
+	requestID := c.Param("request_id")
+	query, ok := h.running.Cancel(requestID)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error: "No running query with this request ID",
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	adminRequestID, _ := c.Get("request_id")
+	logrus.WithFields(logrus.Fields{
+		"request_id":           adminRequestID,
+		"principal":            principalName(c),
+		"cancelled_request_id": requestID,
+		"sql":                  query.SQL,
+	}).Warn("Query cancelled by administrator")
+
+	c.JSON(http.StatusOK, query)
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// ListAudit returns audit records, newest first, filtered by the from and to
+// times (RFC 3339) and principal query parameters. With verify=true the
+// hash chain of the whole log is checked as well.
+func (h *AdminHandler) ListAudit(c *gin.Context) {
+	if h.audit == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error: "Audit log is not enabled",
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	filter, verify, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	records, err := h.audit.Read(filter)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read audit log")
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error: "Failed to read audit log",
+			Time:  time.Now(),
+		})
+		return
+	}
+	if records == nil {
+		records = []audit.Record{}
+	}
+
+	response := gin.H{
+		"records": records,
+		"count":   len(records),
+	}
+	if verify {
+		if err := h.audit.Verify(); err != nil {
+			response["verified"] = false
+			response["verify_error"] = err.Error()
+		} else {
+			response["verified"] = true
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func auditQuery(c *gin.Context) (filter audit.Filter, verify bool, err error) {
+	filter.Principal = c.Query("principal")
+	filter.Limit = defaultAuditLimit
+
+	for param, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(param); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, false, fmt.Errorf("%s must be an RFC 3339 time, got %q", param, value)
+			}
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			return filter, false, fmt.Errorf("limit must be between 1 and %d, got %q", maxAuditLimit, value)
+		}
+	}
+
+	if value := c.Query("verify"); value != "" {
+		if verify, err = strconv.ParseBool(value); err != nil {
+			return filter, false, fmt.Errorf("verify must be true or false, got %q", value)
+		}
+	}
+	return filter, verify, nil
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/metrics"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// priorityHeader lets clients run their queries as batch queries, behind
+// interactive ones.
+const priorityHeader = "X-Query-Priority"
+
+// admittedKey holds the release function of an admitted request, so that
+// a request is admitted once however often admit is called.
+const admittedKey = "admission_release"
+
+// admit waits until the query may run and returns the function to call
+// when it is done. It writes the error response and returns false if the
+// query was not admitted. Once admitted, later calls for the request
+// return the same release function, which only releases the slot once.
+func (h *QueryHandler) admit(c *gin.Context) (release func(), ok bool) {
+	if h.admission == nil {
+		return func() {}, true
+	}
+	if release, ok := c.Get(admittedKey); ok {
+		return release.(func()), true
+	}
+
+	priority := admission.Interactive
+	header := c.GetHeader(priorityHeader)
+	if header != "" {
+		var err error
+		if priority, err = admission.ParsePriority(header); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error: priorityHeader + ": " + err.Error(),
+				Time:  time.Now(),
+			})
+			return nil, false
+		}
+	}
+
+	limit, limited := h.roleLimit(c)
+	if limited && priority < limit.MaxPriority {
+		if header != "" {
+			err := fmt.Errorf("%s priority is not allowed: your roles allow %s queries only", priority, limit.MaxPriority)
+			notAdmitted(c, priority, audit.OutcomeDenied, err)
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Error: err.Error(),
+				Code:  models.CodePriorityNotAllowed,
+				Time:  time.Now(),
+			})
+			return nil, false
+		}
+		priority = limit.MaxPriority
+	}
+	priority = h.admission.Priority(principalName(c), priority)
+
+	endReservation, ok := h.reserve(c, priority, limit.MaxConcurrent)
+	if !ok {
+		return nil, false
+	}
+
+	ctx, span := tracing.Start(c.Request.Context(), "admission.wait",
+		trace.WithAttributes(attribute.String("goduck.priority", priority.String())))
+	releaseSlot, err := h.admission.Acquire(ctx, priority)
+	tracing.End(span, err)
+	if err == nil {
+		release = func() {
+			releaseSlot()
+			endReservation()
+		}
+		c.Set(admittedKey, release)
+		return release, true
+	}
+
+	endReservation()
+	notAdmitted(c, priority, audit.OutcomeFailed, err)
+
+	code := models.CodeQueueTimeout
+	if errors.Is(err, admission.ErrQueueFull) {
+		code = models.CodeQueueFull
+	}
+	retryAfter := int(math.Ceil(h.admission.QueueTimeout().Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+		Error: err.Error(),
+		Code:  code,
+		Time:  time.Now(),
+	})
+	return nil, false
+}
+
+// reserveJob counts a job against the concurrency ceiling of the
+// principal's roles, like a query, and returns the function ending the
+// reservation. It writes the error response and returns false if the
+// principal is at its ceiling.
+func (h *QueryHandler) reserveJob(c *gin.Context) (release func(), ok bool) {
+	if h.admission == nil {
+		return func() {}, true
+	}
+	limit, _ := h.roleLimit(c)
+	return h.reserve(c, admission.Batch, limit.MaxConcurrent)
+}
+
+// roleLimit returns the ceiling of the request's principal.
+func (h *QueryHandler) roleLimit(c *gin.Context) (admission.RoleLimit, bool) {
+	var roles []string
+	if p := auth.PrincipalFrom(c); p != nil {
+		roles = p.Roles
+	}
+	return h.admission.RoleLimit(roles)
+}
+
+// reserve counts a query at priority against max, the number of queries
+// the principal may have in progress. It writes the error response and
+// returns false if the principal is at max.
+func (h *QueryHandler) reserve(c *gin.Context, priority admission.Priority, max int) (release func(), ok bool) {
+	release, err := h.admission.Reserve(principalName(c), max)
+	if err != nil {
+		metrics.QueueRejected(priority.String(), "concurrency")
+		notAdmitted(c, priority, audit.OutcomeDenied, err)
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
+			Error: err.Error(),
+			Code:  models.CodeTooManyQueries,
+			Time:  time.Now(),
+		})
+		return nil, false
+	}
+	return release, true
+}
+
+// notAdmitted logs and audits a query refused admission.
+func notAdmitted(c *gin.Context, priority admission.Priority, outcome string, err error) {
+	requestID, _ := c.Get("request_id")
+	logrus.WithFields(logrus.Fields{
+		"request_id": requestID,
+		"principal":  principalName(c),
+		"priority":   priority.String(),
+		"error":      err.Error(),
+	}).Warn("Query not admitted")
+	audit.EntryFrom(c).Fail(outcome, err)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// streamRecords answers a query in a columnar format built from DuckDB's
+// Arrow record batches, bypassing row scanning entirely. Neither Arrow IPC
+// nor Parquet has room for a trailer, so an error after the first batch
+// aborts the connection and the client sees a truncated stream instead of a
+// silently short result.
+func (h *QueryHandler) streamRecords(c *gin.Context, q querier, format results.Format, req models.QueryRequest, query string, args []interface{}) {
+	var props *parquet.WriterProperties
+	if format == results.FormatParquet {
+		var err error
+		if props, err = results.ParquetProperties(req.Parquet); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error: err.Error(),
+				Time:  time.Now(),
+			})
+			return
+		}
+	}
+
+	start := time.Now()
+
+	ctx, running, done, ok := h.startQuery(c, req.SQL)
+	if !ok {
+		return
+	}
+	defer done()
+
+	requestID, _ := c.Get("request_id")
+
+	queryCtx, span := tracing.StartQuery(ctx, req.SQL)
+	reader, release, err := q.QueryArrow(queryCtx, query, args...)
+	tracing.End(span, err)
+	if errors.Is(err, database.ErrArrowUnavailable) {
+		c.JSON(http.StatusNotAcceptable, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+	if err != nil {
+		queryFailed(c, running, req.SQL, err)
+		return
+	}
+	defer release()
+
+	_, span = tracing.Start(c.Request.Context(), "response.stream")
+	fl := beginStream(c, format, req.Filename)
+	var count int
+	if format == results.FormatParquet {
+		count, err = results.WriteParquet(c.Writer, reader, props, fl.flush)
+	} else {
+		count, err = results.WriteArrow(c.Writer, reader, fl.flush)
+	}
+	fl.flush()
+	span.SetAttributes(tracing.RowsKey.Int(count))
+	tracing.End(span, err)
+	audit.EntryFrom(c).Rows(int64(count))
+
+	entry := logrus.WithFields(logrus.Fields{
+		"request_id":     requestID,
+		"principal":      principalName(c),
+		"sql":            req.SQL,
+		"format":         format,
+		"execution_time": time.Since(start),
+		"row_count":      count,
+	})
+	if err != nil {
+		audit.EntryFrom(c).Fail(audit.OutcomeFailed, err)
+		entry.WithError(err).Error("Query failed while streaming")
+		panic(http.ErrAbortHandler)
+	}
+	entry.Info("Query streamed successfully")
+}
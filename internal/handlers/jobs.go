@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/params"
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler serves asynchronous query jobs. Results are read back through
+// the QueryHandler, so every /query format is available.
+type JobHandler struct {
+	jobs    *jobs.Manager
+	queries *QueryHandler
+}
+
+func NewJobHandler(manager *jobs.Manager, queries *QueryHandler) *JobHandler {
+	return &JobHandler{
+		jobs:    manager,
+		queries: queries,
+	}
+}
+
+func (h *JobHandler) Submit(c *gin.Context) {
+	var req models.QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	err := checkSQL(req.SQL)
+	if err == nil && (req.Limit != 0 || req.PageToken != "") {
+		err = errors.New("limit and page_token are not supported for jobs")
+	}
+	if err == nil && req.Format != "" {
+		_, err = results.Negotiate(req.Format, "")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	args, err := params.Parse(req.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	class, ok := checkStatement(c, h.queries.policy, req.SQL)
+	if !ok {
+		return
+	}
+	// The job counts against the principal's ceiling until its query is
+	// done; it is admitted as a batch query when a worker picks it up.
+	release, ok := h.queries.reserveJob(c)
+	if !ok {
+		return
+	}
+	h.queries.auditTables(c, req.SQL)
+	query, ok := h.queries.applyAccess(c, req.SQL)
+	if !ok {
+		release()
+		return
+	}
+
+	args = h.queries.resolveParams(c, class, query, args)
+	job, err := h.jobs.Submit(c.Request.Context(), principalName(c), req, query, args, release)
+	if err != nil {
+		release()
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	if entry := audit.EntryFrom(c); entry != nil {
+		entry.Job(job.ID)
+		entry.Detach()
+		go auditJob(entry, job)
+	}
+
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job.Status())
+}
+
+func (h *JobHandler) Status(c *gin.Context) {
+	job, ok := h.job(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job.Status())
+}
+
+// Result streams a succeeded job's result. The format is chosen like for
+// /query: ?format=, then the format given at submission, then Accept.
+func (h *JobHandler) Result(c *gin.Context) {
+	job, ok := h.job(c)
+	if !ok {
+		return
+	}
+
+	req := job.Request
+	if f := c.Query("format"); f != "" {
+		req.Format = f
+	}
+	if name := c.Query("filename"); name != "" {
+		req.Filename = name
+	}
+	format, err := results.Negotiate(req.Format, c.GetHeader("Accept"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	conn, release, ok := job.Acquire()
+	if !ok {
+		status := job.Status()
+		message := fmt.Sprintf("Job is %s", status.State)
+		if status.Error != "" {
+			message = fmt.Sprintf("Job %s: %s", status.State, status.Error)
+		}
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error: message,
+			Time:  time.Now(),
+		})
+		return
+	}
+	defer release()
+
+	h.queries.respond(c, conn, format, req, "SELECT * FROM "+jobs.ResultTable, nil)
+}
+
+func (h *JobHandler) Delete(c *gin.Context) {
+	job, ok := h.job(c)
+	if !ok {
+		return
+	}
+	status, ok := h.jobs.Delete(job.ID)
+	if !ok {
+		jobNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// job returns the job named in the path. A job submitted by another
+// principal is reported as not found, like an unknown one, since its result
+// may hold rows only that principal may read.
+func (h *JobHandler) job(c *gin.Context) (*jobs.Job, bool) {
+	job, ok := h.jobs.Get(c.Param("id"))
+	if !ok || job.Principal != principalName(c) {
+		jobNotFound(c)
+		return nil, false
+	}
+	return job, true
+}
+
+// auditJob writes the audit record of a job once it is done.
+func auditJob(entry *audit.Entry, job *jobs.Job) {
+	<-job.Done()
+
+	status := job.Status()
+	if status.RowCount != nil {
+		entry.Rows(*status.RowCount)
+	}
+	if status.StartedAt != nil && status.FinishedAt != nil {
+		entry.Duration(status.FinishedAt.Sub(*status.StartedAt))
+	}
+	switch status.State {
+	case jobs.StateFailed:
+		entry.Fail(audit.OutcomeFailed, errors.New(status.Error))
+	case jobs.StateCancelled:
+		entry.Fail(audit.OutcomeCancelled, errors.New(status.Error))
+	}
+	entry.Write()
+}
+
+func jobNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.ErrorResponse{
+		Error: "Job not found or expired",
+		Time:  time.Now(),
+	})
+}
@@ -3,17 +3,33 @@ package handlers
 import (
 	"net/http"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/metrics"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type MetricsResponse struct {
-	Uptime     string        `json:"uptime"`
-	Goroutines int           `json:"goroutines"`
-	Memory     MemoryStats   `json:"memory"`
-	Database   DatabaseStats `json:"database"`
-	Timestamp  string        `json:"timestamp"`
+	Uptime     string          `json:"uptime"`
+	Goroutines int             `json:"goroutines"`
+	Memory     MemoryStats     `json:"memory"`
+	Database   DatabaseStats   `json:"database"`
+	Admission  *AdmissionStats `json:"admission,omitempty"`
+	Timestamp  string          `json:"timestamp"`
+}
+
+type AdmissionStats struct {
+	MaxConcurrent     int `json:"max_concurrent"`
+	Running           int `json:"running"`
+	QueuedInteractive int `json:"queued_interactive"`
+	QueuedBatch       int `json:"queued_batch"`
 }
 
 type MemoryStats struct {
@@ -32,7 +48,24 @@ type DatabaseStats struct {
 
 var startTime = time.Now()
 
+// prometheusHandler serves the server's metrics together with the
+// connection pool statistics of db, including how often and how long
+// queries waited for a connection.
+func prometheusHandler(db *database.DB) http.Handler {
+	pool := prometheus.NewRegistry()
+	pool.MustRegister(collectors.NewDBStatsCollector(db.GetConnection(), "goduck"))
+	return promhttp.HandlerFor(prometheus.Gatherers{metrics.Registry, pool}, promhttp.HandlerOpts{})
+}
+
+// Metrics serves the metrics in the Prometheus exposition format to
+// scrapers, which ask for text/plain or OpenMetrics, or with
+// ?format=prometheus, and as JSON otherwise.
 func (h *QueryHandler) Metrics(c *gin.Context) {
+	if wantsPrometheus(c) {
+		h.prometheus.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
@@ -55,6 +88,26 @@ func (h *QueryHandler) Metrics(c *gin.Context) {
 		},
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
+	if h.admission != nil {
+		stats := h.admission.Stats()
+		metrics.Admission = &AdmissionStats{
+			MaxConcurrent:     stats.MaxConcurrent,
+			Running:           stats.Running,
+			QueuedInteractive: stats.Waiting[admission.Interactive],
+			QueuedBatch:       stats.Waiting[admission.Batch],
+		}
+	}
 
 	c.JSON(http.StatusOK, metrics)
 }
+
+func wantsPrometheus(c *gin.Context) bool {
+	switch c.Query("format") {
+	case "prometheus":
+		return true
+	case "json":
+		return false
+	}
+	accept := c.GetHeader("Accept")
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text")
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var errPagingFormat = errors.New("limit and page_token are only supported for JSON responses")
+
+// paginate splits a materialized result into its first page. If more rows
+// remain, the whole result is stored for the request's principal and a
+// token for the next page returned.
+func (h *QueryHandler) paginate(c *gin.Context, req models.QueryRequest, columns []string, schema []models.ColumnSchema, rows [][]interface{}) ([][]interface{}, string, error) {
+	if req.Limit <= 0 || len(rows) <= req.Limit {
+		return rows, "", nil
+	}
+
+	id, err := h.cursors.Put(&cursor.Result{
+		SQL:       req.SQL,
+		Principal: principalName(c),
+		Columns:   columns,
+		Schema:    schema,
+		Rows:      rows,
+		Limit:     req.Limit,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return rows[:req.Limit], cursor.Token{ID: id, Offset: req.Limit}.String(), nil
+}
+
+// nextPage serves a page of a previously stored result without running the
+// query again. A result stored for another principal is reported as not
+// found, since it may hold rows only that principal may read.
+func (h *QueryHandler) nextPage(c *gin.Context, req models.QueryRequest) {
+	start := time.Now()
+
+	format, err := results.Negotiate(requestedFormat(c, req), c.GetHeader("Accept"))
+	if err == nil && format != results.FormatJSON {
+		err = errPagingFormat
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	token, err := cursor.ParseToken(req.PageToken)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	result, ok := h.cursors.Get(token.ID)
+	if !ok || result.Principal != principalName(c) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error: "Page token not found or expired",
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	if req.SQL != "" && req.SQL != result.SQL {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "page_token was issued for a different query",
+			Time:  time.Now(),
+		})
+		return
+	}
+
+	limit := result.Limit
+	if req.Limit > 0 {
+		limit = req.Limit
+	}
+
+	rows, next := result.Page(token.Offset, limit)
+	response := models.QueryResponse{
+		Columns: result.Columns,
+		Schema:  result.Schema,
+		Rows:    rows,
+		Count:   len(rows),
+		Time:    time.Since(start).String(),
+	}
+	if next >= 0 {
+		response.NextPageToken = cursor.Token{ID: token.ID, Offset: next}.String()
+	}
+
+	requestID, _ := c.Get("request_id")
+	logrus.WithFields(logrus.Fields{
+		"request_id": requestID,
+		"principal":  principalName(c),
+		"offset":     token.Offset,
+		"row_count":  len(rows),
+	}).Info("Query page served")
+
+	c.JSON(http.StatusOK, response)
+}
+
+// tooManyRowsError reports a paged result that exceeds the stored row limit.
+func tooManyRowsError(max int) string {
+	return fmt.Sprintf("Result too large to page (max %d rows); narrow the query or use a streaming format", max)
+}
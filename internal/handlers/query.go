@@ -2,27 +2,63 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/lab1702/goduck/internal/access"
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/cursor"
 	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/params"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/internal/statements"
+	"github.com/lab1702/goduck/internal/tracing"
 	"github.com/lab1702/goduck/pkg/models"
 
+	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// querier runs queries for a response: the shared pool for /query, or the
+// dedicated connection holding a job's result.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryArrow(ctx context.Context, query string, args ...interface{}) (array.RecordReader, func(), error)
+}
+
 type QueryHandler struct {
 	db           *database.DB
 	queryTimeout time.Duration
+	cursors      *cursor.Store
+	running      *registry.Registry
+	policy       *statements.Policy
+	access       *access.Enforcer
+	admission    *admission.Controller
+	prometheus   http.Handler
 }
 
-func NewQueryHandler(db *database.DB, timeout time.Duration) *QueryHandler {
+// NewQueryHandler returns a handler running queries on db. enforcer may be
+// nil if no access policy is configured, and controller nil to run every
+// query at once.
+func NewQueryHandler(db *database.DB, timeout time.Duration, cursors *cursor.Store, running *registry.Registry, policy *statements.Policy, enforcer *access.Enforcer, controller *admission.Controller) *QueryHandler {
 	return &QueryHandler{
 		db:           db,
 		queryTimeout: timeout,
+		cursors:      cursors,
+		running:      running,
+		policy:       policy,
+		access:       enforcer,
+		admission:    controller,
+		prometheus:   prometheusHandler(db),
 	}
 }
 
@@ -36,42 +72,128 @@ func (h *QueryHandler) ExecuteQuery(c *gin.Context) {
 		return
 	}
 
-	if strings.TrimSpace(req.SQL) == "" {
+	if req.PageToken != "" {
+		if !requireScope(c, auth.ScopeQueryRead) {
+			return
+		}
+		h.nextPage(c, req)
+		return
+	}
+
+	if err := checkSQL(req.SQL); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "SQL query cannot be empty",
+			Error: err.Error(),
 			Time:  time.Now(),
 		})
 		return
 	}
 
-	// Limit query size to prevent abuse
-	if len(req.SQL) > 10000 { // 10KB limit
+	if req.Limit < 0 {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "SQL query too large (max 10KB)",
+			Error: "limit must not be negative",
 			Time:  time.Now(),
 		})
 		return
 	}
 
-	start := time.Now()
-
-	ctx, cancel := context.WithTimeout(c.Request.Context(), h.queryTimeout)
-	defer cancel()
+	args, err := params.Parse(req.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return
+	}
 
-	rows, err := h.db.GetConnection().QueryContext(ctx, req.SQL)
+	format, err := results.Negotiate(requestedFormat(c, req), c.GetHeader("Accept"))
+	if err == nil && req.Limit > 0 && format != results.FormatJSON {
+		err = errPagingFormat
+	}
 	if err != nil {
-		requestID, _ := c.Get("request_id")
-		logrus.WithFields(logrus.Fields{
-			"request_id": requestID,
-			"sql":        req.SQL,
-			"error":      err.Error(),
-		}).Error("Query execution failed")
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Query execution failed",
+			Error: err.Error(),
 			Time:  time.Now(),
 		})
 		return
 	}
+
+	class, ok := checkStatement(c, h.policy, req.SQL)
+	if !ok {
+		return
+	}
+
+	// Admitted before the policy work, which also runs on the pool.
+	release, ok := h.admit(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	h.auditTables(c, req.SQL)
+	query, ok := h.applyAccess(c, req.SQL)
+	if !ok {
+		return
+	}
+
+	h.respond(c, h.db, format, req, query, h.resolveParams(c, class, query, args))
+}
+
+// resolveParams returns args, as parsed from the request, with their lists
+// converted for query, the query that runs. Multi-statement queries are not
+// prepared, since the driver would run all but their last statement, so
+// their lists are bound as literals. So are the lists of a query that cannot
+// be prepared, which fails when it runs.
+func (h *QueryHandler) resolveParams(c *gin.Context, class statements.Class, query string, args []interface{}) []interface{} {
+	if !params.HasLists(args) {
+		return args
+	}
+
+	var untyped map[string]bool
+	if class != statements.ClassMulti {
+		untyped, _ = h.db.UntypedParams(c.Request.Context(), query)
+	}
+	return params.Resolve(args, untyped)
+}
+
+// respond runs query, which answers req, on q and writes its result in the
+// given format. query differs from req.SQL when access policies rewrote it;
+// the query is listed, logged and traced as req.SQL, so that the rewritten
+// query, with the filters and masks applied to the principal, is never
+// shown.
+func (h *QueryHandler) respond(c *gin.Context, q querier, format results.Format, req models.QueryRequest, query string, args []interface{}) {
+	if format.Columnar() {
+		h.streamRecords(c, q, format, req, query, args)
+		return
+	}
+
+	var writer results.Writer
+	if format.Streaming() {
+		var err error
+		writer, err = results.NewWriter(format, c.Writer, req.CSV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error: err.Error(),
+				Time:  time.Now(),
+			})
+			return
+		}
+	}
+
+	start := time.Now()
+
+	ctx, running, done, ok := h.startQuery(c, req.SQL)
+	if !ok {
+		return
+	}
+	defer done()
+
+	queryCtx, span := tracing.StartQuery(ctx, req.SQL)
+	rows, err := q.QueryContext(queryCtx, query, args...)
+	tracing.End(span, err)
+	if err != nil {
+		queryFailed(c, running, req.SQL, err)
+		return
+	}
 	defer rows.Close()
 
 	columns, err := rows.Columns()
@@ -84,15 +206,29 @@ func (h *QueryHandler) ExecuteQuery(c *gin.Context) {
 		return
 	}
 
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get column types")
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error: "Failed to process query results",
+			Time:  time.Now(),
+		})
+		return
+	}
+	enc := results.NewEncoder(columnTypes)
+	schema := results.Schema(columnTypes)
+
+	if writer != nil {
+		h.streamRows(c, format, writer, rows, schema, enc, start, req)
+		return
+	}
+
+	_, scan := tracing.Start(c.Request.Context(), "rows.scan")
 	var result [][]interface{}
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
+		values, err := scanRow(rows, enc, len(columns))
+		if err != nil {
+			tracing.End(scan, err)
 			logrus.WithError(err).Error("Failed to scan row")
 			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 				Error: "Failed to process query results",
@@ -101,18 +237,23 @@ func (h *QueryHandler) ExecuteQuery(c *gin.Context) {
 			return
 		}
 
-		for i, val := range values {
-			if val == nil {
-				values[i] = nil
-			} else if b, ok := val.([]byte); ok {
-				values[i] = string(b)
-			}
-		}
-
 		result = append(result, values)
+
+		if req.Limit > 0 && len(result) > h.cursors.MaxRows() {
+			scan.End()
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error: tooManyRowsError(h.cursors.MaxRows()),
+				Time:  time.Now(),
+			})
+			return
+		}
 	}
 
-	if err := rows.Err(); err != nil {
+	err = rows.Err()
+	scan.SetAttributes(tracing.RowsKey.Int(len(result)))
+	tracing.End(scan, err)
+	if err != nil {
+		audit.EntryFrom(c).Fail(audit.OutcomeFailed, err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error: fmt.Sprintf("Row iteration error: %v", err),
 			Time:  time.Now(),
@@ -120,24 +261,136 @@ func (h *QueryHandler) ExecuteQuery(c *gin.Context) {
 		return
 	}
 
+	page, nextPageToken, err := h.paginate(c, req, columns, schema, result)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to store query result")
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error: "Failed to process query results",
+			Time:  time.Now(),
+		})
+		return
+	}
+
 	duration := time.Since(start)
+	audit.EntryFrom(c).Rows(int64(len(result)))
 
 	requestID, _ := c.Get("request_id")
 	logrus.WithFields(logrus.Fields{
 		"request_id":     requestID,
+		"principal":      principalName(c),
 		"sql":            req.SQL,
 		"execution_time": duration,
 		"row_count":      len(result),
 	}).Info("Query executed successfully")
 
+	_, encode := tracing.Start(c.Request.Context(), "response.encode")
+	defer encode.End()
 	c.JSON(http.StatusOK, models.QueryResponse{
-		Columns: columns,
-		Rows:    result,
-		Count:   len(result),
-		Time:    duration.String(),
+		Columns:       columns,
+		Schema:        schema,
+		Rows:          page,
+		Count:         len(page),
+		Time:          duration.String(),
+		NextPageToken: nextPageToken,
+	})
+}
+
+// startQuery waits until the query is admitted, derives the context it runs
+// under and registers it under its request ID so that it can be listed and
+// cancelled. The returned function must be called when the query is done.
+// If the query is not admitted or the request ID is already in use, an
+// error response is written and ok is false.
+func (h *QueryHandler) startQuery(c *gin.Context, sql string) (ctx context.Context, query *registry.Query, done func(), ok bool) {
+	requestID := c.GetString("request_id")
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+
+	release, ok := h.admit(c)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.queryTimeout)
+	query, unregister, err := h.running.Register(requestID, sql, c.ClientIP(), cancel)
+	if err != nil {
+		cancel()
+		release()
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+		return nil, nil, nil, false
+	}
+
+	done = func() {
+		unregister()
+		cancel()
+		release()
+	}
+	return ctx, query, done, true
+}
+
+// queryFailed logs a query that failed before any result was sent and
+// writes the error response.
+func queryFailed(c *gin.Context, query *registry.Query, sql string, err error) {
+	requestID, _ := c.Get("request_id")
+	logrus.WithFields(logrus.Fields{
+		"request_id": requestID,
+		"principal":  principalName(c),
+		"sql":        sql,
+		"error":      err.Error(),
+	}).Error("Query execution failed")
+
+	message := "Query execution failed"
+	outcome := audit.OutcomeFailed
+	if query.Cancelled() {
+		message = "Query cancelled"
+		outcome = audit.OutcomeCancelled
+	}
+	audit.EntryFrom(c).Fail(outcome, err)
+	c.JSON(http.StatusBadRequest, models.ErrorResponse{
+		Error: message,
+		Time:  time.Now(),
 	})
 }
 
+func checkSQL(sql string) error {
+	if strings.TrimSpace(sql) == "" {
+		return errors.New("SQL query cannot be empty")
+	}
+
+	// Limit query size to prevent abuse
+	if len(sql) > 10000 { // 10KB limit
+		return errors.New("SQL query too large (max 10KB)")
+	}
+	return nil
+}
+
+// requestedFormat returns the format named in the request body or, failing
+// that, the format query parameter.
+func requestedFormat(c *gin.Context, req models.QueryRequest) string {
+	if req.Format != "" {
+		return req.Format
+	}
+	return c.Query("format")
+}
+
+func scanRow(rows *sql.Rows, enc *results.Encoder, n int) ([]interface{}, error) {
+	values := make([]interface{}, n)
+	valuePtrs := make([]interface{}, n)
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+
+	enc.Row(values)
+	return values, nil
+}
+
 func (h *QueryHandler) Health(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/statements"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// checkStatement classifies sql and rejects it if the policy does not allow
+// its class or the principal lacks the scope for it: query:read for reading
+// classes, query:write for the others. It writes the error response and
+// returns false if the query must not run.
+func checkStatement(c *gin.Context, policy *statements.Policy, sql string) (statements.Class, bool) {
+	requestID, _ := c.Get("request_id")
+
+	ctx, span := tracing.Start(c.Request.Context(), "query.validate")
+	class, err := policy.Check(ctx, sql)
+	span.SetAttributes(tracing.ClassKey.String(string(class)))
+	tracing.End(span, err)
+	audit.EntryFrom(c).Statement(sql, string(class))
+	var notAllowed *statements.NotAllowedError
+	switch {
+	case errors.As(err, &notAllowed):
+		audit.EntryFrom(c).Fail(audit.OutcomeDenied, err)
+		logrus.WithFields(logrus.Fields{
+			"request_id": requestID,
+			"principal":  principalName(c),
+			"sql":        sql,
+			"class":      class,
+		}).Warn("Statement not allowed")
+
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error: notAllowed.Error(),
+			Code:  models.CodeStatementNotAllowed,
+			Time:  time.Now(),
+		})
+		return class, false
+	case err != nil:
+		audit.EntryFrom(c).Fail(audit.OutcomeFailed, err)
+		logrus.WithFields(logrus.Fields{
+			"request_id": requestID,
+			"principal":  principalName(c),
+			"sql":        sql,
+			"error":      err.Error(),
+		}).Error("Query execution failed")
+
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "Query execution failed",
+			Time:  time.Now(),
+		})
+		return class, false
+	}
+
+	scope := auth.ScopeQueryWrite
+	if class.ReadOnly() {
+		scope = auth.ScopeQueryRead
+	}
+	return class, requireScope(c, scope)
+}
+
+// requireScope writes a 403 response and returns false if the principal
+// lacks scope.
+func requireScope(c *gin.Context, scope string) bool {
+	if auth.Allowed(c, scope) {
+		return true
+	}
+	audit.EntryFrom(c).Fail(audit.OutcomeDenied, errors.New("missing required scope: "+scope))
+	c.JSON(http.StatusForbidden, models.ErrorResponse{
+		Error: "Missing required scope: " + scope,
+		Code:  models.CodeInsufficientScope,
+		Time:  time.Now(),
+	})
+	return false
+}
+
+// principalName returns the name of the request's principal for logging, or
+// an empty string if authentication is disabled.
+func principalName(c *gin.Context) string {
+	if p := auth.PrincipalFrom(c); p != nil {
+		return p.Name
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"database/sql"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// flushEvery is the number of rows written between flushes to the client.
+	flushEvery = 1000
+	// streamWriteTimeout bounds how long a single flush may block on a slow
+	// client; the deadline is extended after every flush so long streams are
+	// not cut off by the server-wide WriteTimeout.
+	streamWriteTimeout = 15 * time.Second
+)
+
+// flusher pushes buffered output to the client and extends the write deadline.
+type flusher struct {
+	c  *gin.Context
+	rc *http.ResponseController
+}
+
+// beginStream sends the response headers for a streamed result. Download
+// formats are sent as an attachment named after filename.
+func beginStream(c *gin.Context, format results.Format, filename string) *flusher {
+	fl := &flusher{c: c, rc: http.NewResponseController(c.Writer)}
+	fl.extendDeadline()
+	c.Header("Content-Type", format.ContentType())
+	if ext := format.Extension(); ext != "" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": downloadName(filename) + ext,
+		}))
+	}
+	c.Status(http.StatusOK)
+	return fl
+}
+
+// downloadName reduces a client-supplied file name to a safe base name.
+func downloadName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	safe := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(safe, "._") == "" {
+		return "result"
+	}
+	return safe
+}
+
+func (fl *flusher) extendDeadline() {
+	// Not every ResponseWriter supports deadlines (e.g. in tests).
+	_ = fl.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+}
+
+func (fl *flusher) flush() {
+	fl.c.Writer.Flush()
+	fl.extendDeadline()
+}
+
+// streamRows writes rows to the client as they are scanned. Once the first
+// byte is sent the status can no longer change, so errors that occur while
+// iterating are reported in the format's trailer, or by aborting the
+// connection for formats without one.
+func (h *QueryHandler) streamRows(c *gin.Context, format results.Format, w results.Writer, rows *sql.Rows, schema []models.ColumnSchema, enc *results.Encoder, start time.Time, req models.QueryRequest) {
+	_, span := tracing.Start(c.Request.Context(), "response.stream")
+	fl := beginStream(c, format, req.Filename)
+
+	count := 0
+	var queryErr, writeErr error
+	if writeErr = w.Begin(schema); writeErr == nil {
+		for rows.Next() {
+			values, err := scanRow(rows, enc, len(schema))
+			if err != nil {
+				queryErr = err
+				break
+			}
+			if writeErr = w.Row(values); writeErr != nil {
+				break
+			}
+			count++
+			if count%flushEvery == 0 {
+				fl.flush()
+			}
+		}
+		if queryErr == nil && writeErr == nil {
+			queryErr = rows.Err()
+		}
+	}
+
+	duration := time.Since(start)
+	if writeErr == nil {
+		writeErr = w.End(results.Summary{Count: count, Elapsed: duration, Err: queryErr})
+		fl.flush()
+	}
+
+	span.SetAttributes(tracing.RowsKey.Int(count))
+	if writeErr != nil {
+		tracing.End(span, writeErr)
+	} else {
+		tracing.End(span, queryErr)
+	}
+
+	audit.EntryFrom(c).Rows(int64(count))
+	requestID, _ := c.Get("request_id")
+	entry := logrus.WithFields(logrus.Fields{
+		"request_id":     requestID,
+		"principal":      principalName(c),
+		"sql":            req.SQL,
+		"format":         format,
+		"execution_time": duration,
+		"row_count":      count,
+	})
+	switch {
+	case writeErr != nil:
+		audit.EntryFrom(c).Fail(audit.OutcomeFailed, writeErr)
+		entry.WithError(writeErr).Warn("Streaming response aborted")
+	case queryErr != nil:
+		audit.EntryFrom(c).Fail(audit.OutcomeFailed, queryErr)
+		entry.WithError(queryErr).Error("Query failed while streaming")
+		if !format.HasTrailer() {
+			panic(http.ErrAbortHandler)
+		}
+	default:
+		entry.Info("Query streamed successfully")
+	}
+}
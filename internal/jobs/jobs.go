@@ -0,0 +1,469 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Job states.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// ResultTable is the temporary table holding a finished job's result on the
+// job's dedicated connection.
+const ResultTable = "temp.goduck_job_result"
+
+var (
+	ErrClosed      = errors.New("job manager is shut down")
+	ErrQueueFull   = errors.New("job queue is full")
+	ErrTooManyJobs = errors.New("too many jobs retained; delete finished jobs or wait for them to expire")
+)
+
+// Config sizes the job subsystem.
+type Config struct {
+	Workers   int
+	QueueSize int
+	MaxJobs   int
+	Timeout   time.Duration
+	ResultTTL time.Duration
+	// Admission, if not nil, admits each job as a batch query before it
+	// runs, so that jobs share the query slots with /query.
+	Admission *admission.Controller
+}
+
+// Job is a query run in the background. Its result is materialized into a
+// temporary table on a dedicated connection, so it can be read back in any
+// format until it expires.
+type Job struct {
+	ID      string
+	Request models.QueryRequest
+	// Principal is the name of the principal that submitted the job, or
+	// empty if authentication is disabled.
+	Principal string
+
+	// query is the SQL run for Request, which differs from Request.SQL
+	// when access policies rewrote it. It is never shown, logged or traced.
+	query string
+	args  []interface{}
+	// release ends the job's reservation against its principal's query
+	// ceiling once its query is done.
+	release func()
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// link connects the job's span to the span of the request submitting it.
+	link trace.Link
+
+	// done is closed once the job has reached its final state.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	mutex     sync.Mutex
+	state     string
+	err       error
+	rowCount  int64
+	submitted time.Time
+	started   time.Time
+	finished  time.Time
+	expires   time.Time
+	discarded bool
+
+	// connMutex serializes reads of the result and closing the connection.
+	connMutex sync.Mutex
+	conn      *database.Conn
+}
+
+// Status returns a snapshot of the job's state.
+func (j *Job) Status() models.JobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	status := models.JobStatus{
+		ID:          j.ID,
+		State:       j.state,
+		SQL:         j.Request.SQL,
+		SubmittedAt: j.submitted,
+	}
+	switch {
+	case j.started.IsZero():
+	case !j.finished.IsZero():
+		status.Elapsed = j.finished.Sub(j.started).String()
+	case !j.started.IsZero():
+		status.Elapsed = time.Since(j.started).String()
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		status.StartedAt = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.FinishedAt = &finished
+	}
+	if j.state == StateSucceeded {
+		rowCount, expires := j.rowCount, j.expires
+		status.RowCount = &rowCount
+		status.ExpiresAt = &expires
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	return status
+}
+
+// Done returns a channel that is closed once the job has succeeded, failed
+// or been cancelled.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *Job) finish() {
+	j.doneOnce.Do(func() { close(j.done) })
+}
+
+// State returns the job's current state.
+func (j *Job) State() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.state
+}
+
+// Acquire reserves the connection holding a succeeded job's result. The
+// caller must call the returned release function when done reading.
+func (j *Job) Acquire() (*database.Conn, func(), bool) {
+	if j.State() != StateSucceeded {
+		return nil, nil, false
+	}
+	j.connMutex.Lock()
+	if j.conn == nil {
+		j.connMutex.Unlock()
+		return nil, nil, false
+	}
+	return j.conn, j.connMutex.Unlock, true
+}
+
+func (j *Job) closeConn() {
+	j.connMutex.Lock()
+	defer j.connMutex.Unlock()
+	if j.conn != nil {
+		j.conn.Close()
+		j.conn = nil
+	}
+}
+
+// Manager runs jobs on a bounded pool of workers and keeps finished jobs
+// until their result TTL expires.
+type Manager struct {
+	db    *database.DB
+	cfg   Config
+	queue chan *Job
+
+	jobs  map[string]*Job
+	mutex sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewManager(db *database.DB, cfg Config) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		db:     db,
+		cfg:    cfg,
+		queue:  make(chan *Job, cfg.QueueSize),
+		jobs:   make(map[string]*Job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+
+	// Cleanup routine
+	go m.cleanupExpired()
+	return m
+}
+
+// Submit queues query, run for principal to answer req, with its parsed
+// bind parameters args. The job reports req.SQL, so that a query rewritten
+// by access policies is never shown. The job's span is linked to the span
+// of ctx and tagged with its request ID. release, if not nil, is called
+// once the job's query is done or the job is cancelled before it ran; it
+// is not called if Submit fails.
+func (m *Manager) Submit(ctx context.Context, principal string, req models.QueryRequest, query string, args []interface{}, release func()) (*Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	if len(m.jobs) >= m.cfg.MaxJobs {
+		return nil, ErrTooManyJobs
+	}
+
+	jobCtx := m.ctx
+	if requestID, ok := tracing.RequestID(ctx); ok {
+		jobCtx = tracing.WithRequestID(jobCtx, requestID)
+	}
+	jobCtx, cancel := context.WithCancel(jobCtx)
+	var releaseOnce sync.Once
+	end := func() {
+		if release != nil {
+			releaseOnce.Do(release)
+		}
+	}
+	job := &Job{
+		ID:        uuid.New().String(),
+		Request:   req,
+		Principal: principal,
+		query:     query,
+		args:      args,
+		release:   end,
+		ctx:       jobCtx,
+		cancel:    cancel,
+		link:      trace.LinkFromContext(ctx),
+		done:      make(chan struct{}),
+		state:     StateQueued,
+		submitted: time.Now(),
+	}
+
+	select {
+	case m.queue <- job:
+	default:
+		cancel()
+		return nil, ErrQueueFull
+	}
+	m.jobs[job.ID] = job
+	return job, nil
+}
+
+// Get returns a job by ID.
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Delete cancels a queued or running job, or discards a finished job's
+// result, and forgets the job. It returns the job's final status.
+func (m *Manager) Delete(id string) (models.JobStatus, bool) {
+	m.mutex.Lock()
+	job, ok := m.jobs[id]
+	delete(m.jobs, id)
+	m.mutex.Unlock()
+
+	if !ok {
+		return models.JobStatus{}, false
+	}
+	m.discard(job)
+	return job.Status(), true
+}
+
+// Close cancels all jobs, waits for the workers to stop and releases every
+// retained result.
+func (m *Manager) Close() {
+	m.mutex.Lock()
+	m.cancel()
+	jobs := m.jobs
+	m.jobs = make(map[string]*Job)
+	m.mutex.Unlock()
+
+	m.wg.Wait()
+	for _, job := range jobs {
+		m.discard(job)
+	}
+}
+
+func (m *Manager) discard(job *Job) {
+	job.mutex.Lock()
+	job.discarded = true
+	queued := job.state == StateQueued
+	if queued || job.state == StateRunning {
+		job.state = StateCancelled
+		job.err = context.Canceled
+	}
+	job.mutex.Unlock()
+
+	// A running job is done when its query returns.
+	if queued {
+		job.release()
+		job.finish()
+	}
+
+	job.cancel()
+	// A reader may still be streaming the result; close once it is done.
+	go job.closeConn()
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case job := <-m.queue:
+			m.run(job)
+		}
+	}
+}
+
+func (m *Manager) run(job *Job) {
+	defer job.finish()
+	defer job.cancel()
+
+	ctx, span := tracing.Start(job.ctx, "job.run",
+		trace.WithLinks(job.link),
+		trace.WithAttributes(tracing.JobIDKey.String(job.ID)),
+	)
+	releaseSlot, err := m.admit(ctx)
+	if err != nil {
+		tracing.End(span, err)
+		job.release()
+		m.finished(job, nil, 0, err)
+		return
+	}
+	defer releaseSlot()
+
+	job.mutex.Lock()
+	if job.discarded {
+		job.mutex.Unlock()
+		span.End()
+		return
+	}
+	job.state = StateRunning
+	job.started = time.Now()
+	job.mutex.Unlock()
+
+	conn, rowCount, err := m.materialize(ctx, job)
+	span.SetAttributes(tracing.RowsKey.Int64(rowCount))
+	tracing.End(span, err)
+	job.release()
+	m.finished(job, conn, rowCount, err)
+}
+
+// admit waits until the job may run as a batch query and returns the
+// function releasing its query slot.
+func (m *Manager) admit(ctx context.Context) (release func(), err error) {
+	if m.cfg.Admission == nil {
+		return func() {}, nil
+	}
+	ctx, span := tracing.Start(ctx, "admission.wait",
+		trace.WithAttributes(attribute.String("goduck.priority", admission.Batch.String())))
+	release, err = m.cfg.Admission.Acquire(ctx, admission.Batch)
+	tracing.End(span, err)
+	return release, err
+}
+
+// finished records the outcome of running job: its result connection conn
+// and row count, or err.
+func (m *Manager) finished(job *Job, conn *database.Conn, rowCount int64, err error) {
+	if conn != nil {
+		job.connMutex.Lock()
+		job.conn = conn
+		job.connMutex.Unlock()
+	}
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	job.finished = time.Now()
+	job.expires = job.finished.Add(m.cfg.ResultTTL)
+	if job.discarded {
+		go job.closeConn()
+		return
+	}
+
+	entry := logrus.WithFields(logrus.Fields{
+		"job_id":         job.ID,
+		"sql":            job.Request.SQL,
+		"execution_time": job.finished.Sub(job.started),
+	})
+	switch {
+	case err == nil:
+		job.state = StateSucceeded
+		job.rowCount = rowCount
+		entry.WithField("row_count", rowCount).Info("Job succeeded")
+	case errors.Is(err, context.Canceled):
+		job.state = StateCancelled
+		job.err = err
+		entry.Info("Job cancelled")
+	default:
+		job.state = StateFailed
+		job.err = err
+		entry.WithError(err).Error("Job failed")
+	}
+}
+
+// materialize runs the job's query into its result table on a new
+// dedicated connection. The timeout starts when the job leaves the queue.
+func (m *Manager) materialize(ctx context.Context, job *Job) (*database.Conn, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	conn, err := m.db.DedicatedConn(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queryCtx, span := tracing.StartQuery(ctx, job.Request.SQL)
+	res, err := conn.ExecContext(queryCtx, "CREATE TEMP TABLE "+ResultTable+" AS "+job.query, job.args...)
+	tracing.End(span, err)
+	if err == nil {
+		var rowCount int64
+		if rowCount, err = res.RowsAffected(); err == nil {
+			return conn, rowCount, nil
+		}
+	}
+	conn.Close()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return nil, 0, err
+}
+
+func (m *Manager) cleanupExpired() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+
+		now := time.Now()
+		var expired []*Job
+		m.mutex.Lock()
+		for id, job := range m.jobs {
+			job.mutex.Lock()
+			if !job.expires.IsZero() && now.After(job.expires) {
+				delete(m.jobs, id)
+				expired = append(expired, job)
+			}
+			job.mutex.Unlock()
+		}
+		m.mutex.Unlock()
+
+		for _, job := range expired {
+			m.discard(job)
+		}
+	}
+}
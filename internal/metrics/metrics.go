@@ -0,0 +1,142 @@
+// Package metrics holds the Prometheus metrics of the server.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lab1702/goduck/internal/audit"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "goduck"
+
+// Registry holds the server's metrics and the Go runtime and process
+// metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	queries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queries_total",
+		Help:      "Statements run through /query and /jobs, by outcome and statement class.",
+	}, []string{"status", "class"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "query_duration_seconds",
+		Help:      "Time spent handling statements, by statement class.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"class"})
+
+	queryRows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "query_rows_returned",
+		Help:      "Rows returned by successful statements, by statement class.",
+		Buckets:   prometheus.ExponentialBuckets(1, 10, 8),
+	}, []string{"class"})
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	responseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Bytes written in HTTP response bodies, by route.",
+	}, []string{"route"})
+
+	rateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by the rate limiter.",
+	})
+
+	queriesRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queries_running",
+		Help:      "Queries admitted and running.",
+	})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "query_queue_depth",
+		Help:      "Queries waiting to be admitted, by priority.",
+	}, []string{"priority"})
+
+	queueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "query_queue_wait_seconds",
+		Help:      "Time admitted queries waited for a slot, by priority.",
+		Buckets:   []float64{0, .001, .01, .05, .1, .5, 1, 5, 10, 30, 60},
+	}, []string{"priority"})
+
+	queueRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "query_queue_rejected_total",
+		Help:      "Queries refused because the queue was full or their wait timed out, by priority and reason.",
+	}, []string{"priority", "reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		queries, queryDuration, queryRows, requests, responseBytes, rateLimited,
+		queriesRunning, queueDepth, queueWait, queueRejected,
+	)
+}
+
+// ObserveStatement records the outcome, duration and rows of a statement.
+func ObserveStatement(r audit.Record) {
+	class := r.Class
+	if class == "" {
+		class = "unknown"
+	}
+	queries.WithLabelValues(r.Outcome, class).Inc()
+	queryDuration.WithLabelValues(class).Observe(r.DurationMS / float64(time.Second/time.Millisecond))
+	if r.Outcome == audit.OutcomeSuccess {
+		queryRows.WithLabelValues(class).Observe(float64(r.Rows))
+	}
+}
+
+// ObserveRequest records an HTTP request to route and the size of its
+// response body.
+func ObserveRequest(route, method string, code, bytes int) {
+	if route == "" {
+		route = "unmatched"
+	}
+	requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	if bytes > 0 {
+		responseBytes.WithLabelValues(route).Add(float64(bytes))
+	}
+}
+
+// RateLimited records a request rejected by the rate limiter.
+func RateLimited() {
+	rateLimited.Inc()
+}
+
+// SetQueriesRunning records the number of admitted queries.
+func SetQueriesRunning(n int) {
+	queriesRunning.Set(float64(n))
+}
+
+// SetQueueDepth records the number of queries waiting with priority.
+func SetQueueDepth(priority string, n int) {
+	queueDepth.WithLabelValues(priority).Set(float64(n))
+}
+
+// ObserveQueueWait records how long an admitted query waited.
+func ObserveQueueWait(priority string, wait time.Duration) {
+	queueWait.WithLabelValues(priority).Observe(wait.Seconds())
+}
+
+// QueueRejected records a query refused admission for reason.
+func QueueRejected(priority, reason string) {
+	queueRejected.WithLabelValues(priority, reason).Inc()
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"github.com/lab1702/goduck/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditMiddleware writes the audit record of the statement a request runs
+// to log, unless log is nil, and passes it to observe, unless observe is
+// nil, once the request is done.
+func AuditMiddleware(log *audit.Log, observe func(audit.Record)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if log == nil && observe == nil {
+			c.Next()
+			return
+		}
+
+		entry := audit.Begin(c, log, observe)
+		defer func() {
+			// Streams are aborted by panicking; the statement still ran.
+			if recovered := recover(); recovered != nil {
+				entry.Fail(audit.OutcomeFailed, nil)
+				entry.End(c.Writer.Status())
+				panic(recovered)
+			}
+			entry.End(c.Writer.Status())
+		}()
+		c.Next()
+	}
+}
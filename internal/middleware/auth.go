@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AuthMiddleware authenticates the credential sent as
+// "Authorization: Bearer <credential>" or in the X-API-Key header and
+// attaches the resulting principal to the request. Requests without such a
+// credential are authenticated by their verified TLS client certificate, if
+// authenticator accepts certificates.
+func AuthMiddleware(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		credential := credentialFrom(c.Request)
+		cert := clientCertificate(c.Request)
+		certs, acceptsCerts := authenticator.(auth.CertificateAuthenticator)
+
+		ctx, span := tracing.Start(c.Request.Context(), "auth")
+		var principal *auth.Principal
+		var err error
+		switch {
+		case credential != "":
+			principal, err = authenticator.Authenticate(ctx, credential)
+		case cert != nil && acceptsCerts:
+			principal, err = certs.AuthenticateCertificate(ctx, cert)
+		default:
+			span.End()
+			c.Header("WWW-Authenticate", `Bearer realm="goduck"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorResponse{
+				Error: "Authentication required",
+				Code:  models.CodeUnauthenticated,
+				Time:  time.Now(),
+			})
+			return
+		}
+
+		if principal != nil {
+			span.SetAttributes(tracing.PrincipalKey.String(principal.Name))
+		}
+		tracing.End(span, err)
+
+		if err != nil {
+			entry := logrus.WithFields(logrus.Fields{
+				"request_id": c.GetString("request_id"),
+				"client_ip":  c.ClientIP(),
+			})
+			if errors.Is(err, auth.ErrInvalidCredential) {
+				entry.Warn("Invalid credentials")
+			} else {
+				entry.WithError(err).Warn("Authentication failed")
+			}
+
+			c.Header("WWW-Authenticate", `Bearer realm="goduck", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorResponse{
+				Error: "Invalid credentials",
+				Code:  models.CodeInvalidCredentials,
+				Time:  time.Now(),
+			})
+			return
+		}
+
+		auth.SetPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests whose principal lacks scope. It lets every
+// request through when authentication is disabled.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.Allowed(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorResponse{
+				Error: "Missing required scope: " + scope,
+				Code:  models.CodeInsufficientScope,
+				Time:  time.Now(),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// clientCertificate returns the client certificate the TLS handshake
+// verified, or nil.
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func credentialFrom(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credential, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credential)
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
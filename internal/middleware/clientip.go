@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/lab1702/goduck/internal/clientip"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientIPMiddleware replaces the request's remote address with the client
+// address resolver finds behind trusted proxies, so that c.ClientIP() and
+// everything logging it see the client rather than the proxy. It must run
+// before any middleware reading the client IP, on an engine that trusts no
+// proxies itself.
+func ClientIPMiddleware(resolver *clientip.Resolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if addr, ok := resolver.Resolve(c.Request.RemoteAddr, c.Request.Header); ok {
+			c.Request.RemoteAddr = netip.AddrPortFrom(addr, 0).String()
+		}
+		c.Next()
+	}
+}
+
+// IPFilterMiddleware rejects clients in deny and, if allow is not empty,
+// clients outside allow. Deny takes precedence.
+func IPFilterMiddleware(allow, deny clientip.Prefixes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		addr, err := netip.ParseAddr(c.ClientIP())
+		if err == nil && !deny.Contains(addr) && (len(allow) == 0 || allow.Contains(addr)) {
+			c.Next()
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"request_id": c.GetString("request_id"),
+			"client_ip":  c.ClientIP(),
+		}).Warn("Client address not allowed")
+		c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorResponse{
+			Error: "Client address not allowed",
+			Code:  models.CodeAddressNotAllowed,
+			Time:  time.Now(),
+		})
+	}
+}
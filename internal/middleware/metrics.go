@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/lab1702/goduck/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware counts requests and the bytes written in response to
+// them, by route.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		metrics.ObserveRequest(c.FullPath(), c.Request.Method, c.Writer.Status(), c.Writer.Size())
+	}
+}
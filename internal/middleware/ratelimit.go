@@ -2,89 +2,121 @@ package middleware
 
 import (
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/metrics"
+	"github.com/lab1702/goduck/internal/ratelimit"
+	"github.com/lab1702/goduck/pkg/models"
+
 	"github.com/gin-gonic/gin"
 )
 
+// clientCountedKey marks requests PreAuthMiddleware counted against their
+// client IP.
+const clientCountedKey = "ratelimit_client_counted"
+
+// RateLimiter limits the requests of each client. Authenticated requests
+// are counted against their principal, others against their client IP, so
+// Middleware must run after AuthMiddleware to count principals, and
+// PreAuthMiddleware before it to count requests that fail authentication.
 type RateLimiter struct {
-	visitors map[string]*visitor
-	mutex    sync.RWMutex
-	rate     time.Duration
-	buckets  int
+	limiter *ratelimit.Limiter
+	limit   ratelimit.Limit
+	routes  map[string]ratelimit.Limit
 }
 
-type visitor struct {
-	limiter  chan struct{}
-	lastSeen time.Time
+// NewRateLimiter returns a rate limiter allowing each client limit on every
+// route, except those with their own limit in routes, keyed by the route
+// pattern. Routes with their own limit have a bucket of their own.
+func NewRateLimiter(limit ratelimit.Limit, routes map[string]ratelimit.Limit) *RateLimiter {
+	return &RateLimiter{
+		limiter: ratelimit.NewLimiter(),
+		limit:   limit,
+		routes:  routes,
+	}
 }
 
-func NewRateLimiter(requestsPerMinute int) *RateLimiter {
-	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     time.Minute / time.Duration(requestsPerMinute),
-		buckets:  requestsPerMinute,
+// PreAuthMiddleware counts every request against its client IP before
+// AuthMiddleware runs, so that requests with invalid credentials are limited
+// too. Middleware gives the token back once the request is authenticated
+// and counts it against its principal instead.
+func (rl *RateLimiter) PreAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, limit := rl.route(c, "ip:"+c.ClientIP())
+		if limit.Unlimited() {
+			c.Next()
+			return
+		}
+		c.Set(clientCountedKey, true)
+		if !rl.allow(c, key, limit) {
+			return
+		}
+		c.Next()
 	}
-
-	// Cleanup routine
-	go rl.cleanupVisitors()
-	return rl
 }
 
 func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-
-		if !rl.allow(ip) {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-			})
-			c.Abort()
+		p := auth.PrincipalFrom(c)
+		clientKey, limit := rl.route(c, "ip:"+c.ClientIP())
+		if limit.Unlimited() {
+			c.Next()
 			return
 		}
+		if c.GetBool(clientCountedKey) {
+			if p == nil {
+				// Already counted against the client IP.
+				c.Next()
+				return
+			}
+			rl.limiter.Refund(clientKey, limit)
+		}
 
+		key := clientKey
+		if p != nil {
+			key, _ = rl.route(c, "principal:"+p.Name)
+		}
+		if !rl.allow(c, key, limit) {
+			return
+		}
 		c.Next()
 	}
 }
 
-func (rl *RateLimiter) allow(ip string) bool {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
-
-	v, exists := rl.visitors[ip]
-	if !exists {
-		rl.visitors[ip] = &visitor{
-			limiter:  make(chan struct{}, rl.buckets),
-			lastSeen: time.Now(),
-		}
-		v = rl.visitors[ip]
+// route returns the bucket key of client on the request's route and the
+// limit applying to it.
+func (rl *RateLimiter) route(c *gin.Context, client string) (string, ratelimit.Limit) {
+	if routeLimit, ok := rl.routes[c.FullPath()]; ok {
+		return c.FullPath() + " " + client, routeLimit
 	}
+	return client, rl.limit
+}
 
-	v.lastSeen = time.Now()
-
-	select {
-	case v.limiter <- struct{}{}:
-		go func() {
-			time.Sleep(rl.rate)
-			<-v.limiter
-		}()
+// allow takes a token from the bucket of key and sets the rate limit
+// headers. If the bucket is empty, it aborts the request with 429 and
+// returns false.
+func (rl *RateLimiter) allow(c *gin.Context, key string, limit ratelimit.Limit) bool {
+	d := rl.limiter.Allow(key, limit, time.Now())
+	c.Header("RateLimit-Limit", strconv.Itoa(d.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	c.Header("RateLimit-Reset", seconds(d.Reset))
+	if d.Allowed {
 		return true
-	default:
-		return false
 	}
+	metrics.RateLimited()
+	c.Header("Retry-After", seconds(d.RetryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, models.ErrorResponse{
+		Error: "Rate limit exceeded",
+		Code:  models.CodeRateLimited,
+		Time:  time.Now(),
+	})
+	return false
 }
 
-func (rl *RateLimiter) cleanupVisitors() {
-	for {
-		time.Sleep(time.Minute)
-
-		rl.mutex.Lock()
-		for ip, v := range rl.visitors {
-			if time.Since(v.lastSeen) > 3*time.Minute {
-				delete(rl.visitors, ip)
-			}
-		}
-		rl.mutex.Unlock()
-	}
+// seconds formats d as a number of whole seconds, rounded up so that
+// clients waiting that long are not turned away again.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
 }
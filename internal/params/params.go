@@ -0,0 +1,347 @@
+package params
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Error reports a parameter that could not be converted to a bind value.
+type Error struct {
+	Param  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid parameter %s: %s", e.Param, e.Reason)
+}
+
+// typed is the explicit form of a parameter, e.g.
+// {"type": "timestamp", "value": "2024-01-01T00:00:00Z"}.
+type typed struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Parse converts the "params" field of a query request into arguments for
+// QueryContext. A JSON array binds positionally (? and $1 placeholders) and a
+// JSON object binds by name ($name placeholders). JSON arrays are returned as
+// Lists, which must be passed through Resolve before the query runs.
+func Parse(raw json.RawMessage) ([]interface{}, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	switch raw[0] {
+	case '[':
+		var values []json.RawMessage
+		if err := unmarshal(raw, &values); err != nil {
+			return nil, &Error{Param: "params", Reason: err.Error()}
+		}
+		args := make([]interface{}, len(values))
+		for i, v := range values {
+			name := "$" + strconv.Itoa(i+1)
+			arg, err := convert(name, v)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = arg
+		}
+		return args, nil
+	case '{':
+		var values map[string]json.RawMessage
+		if err := unmarshal(raw, &values); err != nil {
+			return nil, &Error{Param: "params", Reason: err.Error()}
+		}
+		args := make([]interface{}, 0, len(values))
+		for key, v := range values {
+			name := strings.TrimPrefix(key, "$")
+			if !validName(name) {
+				return nil, &Error{Param: "$" + name, Reason: "name must start with a letter and contain only letters, digits and underscores"}
+			}
+			arg, err := convert("$"+name, v)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, sql.Named(name, arg))
+		}
+		return args, nil
+	default:
+		return nil, &Error{Param: "params", Reason: "must be an array or an object"}
+	}
+}
+
+func convert(name string, raw json.RawMessage) (interface{}, error) {
+	var v interface{}
+	if err := unmarshal(raw, &v); err != nil {
+		return nil, &Error{Param: name, Reason: err.Error()}
+	}
+
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return val, nil
+	case string:
+		return val, nil
+	case json.Number:
+		return number(name, val)
+	case []interface{}:
+		return list(name, raw)
+	case map[string]interface{}:
+		return explicit(name, raw)
+	}
+	return nil, &Error{Param: name, Reason: fmt.Sprintf("unsupported value %s", raw)}
+}
+
+func explicit(name string, raw json.RawMessage) (interface{}, error) {
+	var t typed
+	if err := unmarshal(raw, &t); err != nil {
+		return nil, &Error{Param: name, Reason: err.Error()}
+	}
+	if len(t.Value) == 0 {
+		return nil, &Error{Param: name, Reason: `typed parameter requires a "value"`}
+	}
+
+	var v interface{}
+	if err := unmarshal(t.Value, &v); err != nil {
+		return nil, &Error{Param: name, Reason: err.Error()}
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch strings.ToLower(t.Type) {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, &Error{Param: name, Reason: "integer value must be a JSON number"}
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return nil, &Error{Param: name, Reason: fmt.Sprintf("%s is not a 64-bit integer", n)}
+		}
+		return i, nil
+	case "float":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, &Error{Param: name, Reason: "float value must be a JSON number"}
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, &Error{Param: name, Reason: fmt.Sprintf("%s is not a valid float", n)}
+		}
+		return f, nil
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return nil, &Error{Param: name, Reason: "string value must be a JSON string"}
+		}
+		return s, nil
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, &Error{Param: name, Reason: "boolean value must be true or false"}
+		}
+		return b, nil
+	case "timestamp":
+		s, ok := v.(string)
+		if !ok {
+			return nil, &Error{Param: name, Reason: "timestamp value must be an RFC 3339 string"}
+		}
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, &Error{Param: name, Reason: fmt.Sprintf("timestamp %q is not RFC 3339", s)}
+		}
+		return ts, nil
+	case "date":
+		s, ok := v.(string)
+		if !ok {
+			return nil, &Error{Param: name, Reason: "date value must be a YYYY-MM-DD string"}
+		}
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, &Error{Param: name, Reason: fmt.Sprintf("date %q is not YYYY-MM-DD", s)}
+		}
+		return d, nil
+	case "list":
+		if _, ok := v.([]interface{}); !ok {
+			return nil, &Error{Param: name, Reason: "list value must be a JSON array"}
+		}
+		return list(name, t.Value)
+	case "":
+		return nil, &Error{Param: name, Reason: `typed parameter requires a "type"`}
+	}
+	return nil, &Error{Param: name, Reason: fmt.Sprintf("unsupported type %q", t.Type)}
+}
+
+func number(name string, n json.Number) (interface{}, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, &Error{Param: name, Reason: fmt.Sprintf("%s is out of range", n)}
+	}
+	return f, nil
+}
+
+// List is a list parameter. go-duckdb binds a Go slice with the element
+// types DuckDB expects when the query fixes the parameter's type, e.g.
+// "?::INTEGER[]", and panics on any other element type. Such parameters are
+// bound as a list literal, which DuckDB casts; the others, e.g. in
+// "id = ANY(?)", take their type from the bound slice. Resolve picks the
+// form once the query's parameter types are known.
+type List struct {
+	// values holds the elements as a typed slice, or is nil if they are not
+	// all of one type. go-duckdb crashes on NULL elements, so lists holding
+	// them are only bound as literals.
+	values  interface{}
+	literal string
+}
+
+// list converts a JSON array, binding its elements by their JSON type.
+// Whole numbers are widened to doubles when the list also holds fractions.
+func list(name string, raw json.RawMessage) (interface{}, error) {
+	var values []json.RawMessage
+	if err := unmarshal(raw, &values); err != nil {
+		return nil, &Error{Param: name, Reason: err.Error()}
+	}
+
+	elems := make([]interface{}, len(values))
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		elem, err := convert(fmt.Sprintf("%s[%d]", name, i), v)
+		if err != nil {
+			return nil, err
+		}
+		switch e := elem.(type) {
+		case nil:
+			b.WriteString("NULL")
+		case string:
+			quote(&b, e)
+		case time.Time:
+			quote(&b, e.UTC().Format("2006-01-02 15:04:05.999999"))
+		case List:
+			b.WriteString(e.literal)
+			elem = e.values
+		default:
+			fmt.Fprint(&b, e)
+		}
+		elems[i] = elem
+	}
+	b.WriteByte(']')
+	return List{values: slice(elems), literal: b.String()}, nil
+}
+
+// slice returns elems as a slice of their common type, or nil if they have
+// none. An empty list binds as an empty BIGINT list, which DuckDB compares
+// with any type.
+func slice(elems []interface{}) interface{} {
+	elemType := reflect.TypeOf(int64(0))
+	for i, e := range elems {
+		if e == nil {
+			return nil
+		}
+		t := reflect.TypeOf(e)
+		switch {
+		case i == 0 || t == elemType:
+			elemType = t
+		case t.Kind() == reflect.Float64 && elemType.Kind() == reflect.Int64,
+			t.Kind() == reflect.Int64 && elemType.Kind() == reflect.Float64:
+			elemType = reflect.TypeOf(float64(0))
+		default:
+			return nil
+		}
+	}
+
+	s := reflect.MakeSlice(reflect.SliceOf(elemType), len(elems), len(elems))
+	for i, e := range elems {
+		s.Index(i).Set(reflect.ValueOf(e).Convert(elemType))
+	}
+	return s.Interface()
+}
+
+// HasLists reports whether args, as returned by Parse, hold a list.
+func HasLists(args []interface{}) bool {
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			arg = named.Value
+		}
+		if _, ok := arg.(List); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve replaces the lists in args, as returned by Parse, with the values
+// to bind. untyped names the parameters whose type the query leaves to the
+// bound value, positional parameters being named by their position; lists
+// bound to other parameters, or to any parameter if untyped is nil, are
+// bound as list literals.
+func Resolve(args []interface{}, untyped map[string]bool) []interface{} {
+	if !HasLists(args) {
+		return args
+	}
+
+	resolved := make([]interface{}, len(args))
+	for i, arg := range args {
+		name := strconv.Itoa(i + 1)
+		named, isNamed := arg.(sql.NamedArg)
+		if isNamed {
+			name, arg = named.Name, named.Value
+		}
+		if l, ok := arg.(List); ok {
+			arg = l.literal
+			if untyped[name] && l.values != nil {
+				arg = l.values
+			}
+		}
+		if isNamed {
+			arg = sql.Named(name, arg)
+		}
+		resolved[i] = arg
+	}
+	return resolved
+}
+
+func quote(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+}
+
+func validName(name string) bool {
+	for i, r := range name {
+		if i == 0 && !unicode.IsLetter(r) {
+			return false
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func unmarshal(raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,196 @@
+// Package ratelimit implements token bucket rate limits shared by many
+// clients.
+package ratelimit
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shards = 64
+	// sweepInterval is how often a shard drops the buckets of clients that
+	// have been idle long enough for their bucket to refill.
+	sweepInterval = time.Minute
+)
+
+// Limit is a sustained rate and the burst allowed on top of it. A zero
+// Limit lets every request through.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Unlimited reports whether l lets every request through.
+func (l Limit) Unlimited() bool {
+	return l.PerMinute <= 0
+}
+
+// interval is the time it takes to earn one token.
+func (l Limit) interval() time.Duration {
+	return time.Minute / time.Duration(l.PerMinute)
+}
+
+// ParseLimit parses a limit written as "<per minute>" or
+// "<per minute>:<burst>". The burst defaults to the per-minute rate.
+func ParseLimit(s string) (Limit, error) {
+	rate, burst, hasBurst := strings.Cut(strings.TrimSpace(s), ":")
+	perMinute, err := strconv.Atoi(rate)
+	if err != nil || perMinute < 0 {
+		return Limit{}, fmt.Errorf("invalid rate %q", rate)
+	}
+	if perMinute == 0 {
+		return Limit{}, nil
+	}
+	l := Limit{PerMinute: perMinute, Burst: perMinute}
+	if hasBurst {
+		if l.Burst, err = strconv.Atoi(burst); err != nil || l.Burst < 1 {
+			return Limit{}, fmt.Errorf("invalid burst %q", burst)
+		}
+	}
+	return l, nil
+}
+
+// ParseRoutes parses per-route limits written as "<route>=<limit>" entries,
+// e.g. "/query=30:10" or "/metrics=0" to exempt a route.
+func ParseRoutes(entries []string) (map[string]Limit, error) {
+	routes := make(map[string]Limit)
+	for _, entry := range entries {
+		route, limit, ok := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		if !ok || !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid route limit %q", entry)
+		}
+		l, err := ParseLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %v", route, err)
+		}
+		routes[route] = l
+	}
+	return routes, nil
+}
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed bool
+	// Limit is the burst, the most requests the bucket ever allows at once.
+	Limit int
+	// Remaining is the number of requests allowed right now.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, or zero if
+	// one is allowed now.
+	RetryAfter time.Duration
+}
+
+// bucket holds the tokens of one client, as of last. It is full again at
+// full.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+type shard struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Limiter keeps a token bucket per key. Buckets are spread over
+// independently locked shards so concurrent requests rarely contend, and
+// idle buckets are dropped while taking tokens, so the limiter runs no
+// goroutines of its own.
+type Limiter struct {
+	seed   maphash.Seed
+	shards [shards]shard
+}
+
+func NewLimiter() *Limiter {
+	l := &Limiter{seed: maphash.MakeSeed()}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[string]*bucket)
+	}
+	return l
+}
+
+// Allow takes a token from the bucket of key, which refills at limit, as of
+// now.
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) Decision {
+	if limit.Unlimited() {
+		return Decision{Allowed: true}
+	}
+	s := &l.shards[maphash.String(l.seed, key)%shards]
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	burst := float64(limit.Burst)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+float64(elapsed)/float64(limit.interval()))
+		b.last = now
+	}
+
+	d := Decision{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) * float64(limit.interval()))
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = time.Duration((burst - b.tokens) * float64(limit.interval()))
+	b.full = now.Add(d.Reset)
+	return d
+}
+
+// Refund gives back a token Allow took from the bucket of key.
+func (l *Limiter) Refund(key string, limit Limit) {
+	if limit.Unlimited() {
+		return
+	}
+	s := &l.shards[maphash.String(l.seed, key)%shards]
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b, ok := s.buckets[key]; ok {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+		b.full = b.full.Add(-limit.interval())
+	}
+}
+
+// Len returns the number of buckets kept.
+func (l *Limiter) Len() int {
+	n := 0
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mutex.Lock()
+		n += len(s.buckets)
+		s.mutex.Unlock()
+	}
+	return n
+}
+
+// sweep drops the buckets that have refilled, which a new bucket would
+// replace exactly. It is called with the shard locked.
+func (s *shard) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+// ErrDuplicate is returned by Register when a query with the same request ID
+// is already running.
+var ErrDuplicate = errors.New("a query with this request ID is already running")
+
+// Query is a running query.
+type Query struct {
+	RequestID string
+	SQL       string
+	ClientIP  string
+	Started   time.Time
+
+	cancel    context.CancelFunc
+	mutex     sync.Mutex
+	cancelled bool
+}
+
+// Cancelled reports whether the query was cancelled through the registry.
+func (q *Query) Cancelled() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.cancelled
+}
+
+func (q *Query) info() models.RunningQuery {
+	return models.RunningQuery{
+		RequestID: q.RequestID,
+		SQL:       q.SQL,
+		ClientIP:  q.ClientIP,
+		StartedAt: q.Started,
+		Elapsed:   time.Since(q.Started).String(),
+		Cancelled: q.Cancelled(),
+	}
+}
+
+// Registry tracks running queries by request ID so they can be listed and
+// cancelled.
+type Registry struct {
+	queries map[string]*Query
+	mutex   sync.Mutex
+}
+
+func New() *Registry {
+	return &Registry{queries: make(map[string]*Query)}
+}
+
+// Register records a running query whose context is cancelled by cancel.
+// The returned function removes it again and must be called when the query
+// is done.
+func (r *Registry) Register(requestID, sql, clientIP string, cancel context.CancelFunc) (*Query, func(), error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.queries[requestID]; exists {
+		return nil, nil, ErrDuplicate
+	}
+
+	q := &Query{
+		RequestID: requestID,
+		SQL:       sql,
+		ClientIP:  clientIP,
+		Started:   time.Now(),
+		cancel:    cancel,
+	}
+	r.queries[requestID] = q
+
+	unregister := func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.queries[requestID] == q {
+			delete(r.queries, requestID)
+		}
+	}
+	return q, unregister, nil
+}
+
+// List returns the running queries, oldest first.
+func (r *Registry) List() []models.RunningQuery {
+	r.mutex.Lock()
+	list := make([]models.RunningQuery, 0, len(r.queries))
+	for _, q := range r.queries {
+		list = append(list, q.info())
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// Cancel cancels the query with the given request ID.
+func (r *Registry) Cancel(requestID string) (models.RunningQuery, bool) {
+	r.mutex.Lock()
+	q, ok := r.queries[requestID]
+	r.mutex.Unlock()
+
+	if !ok {
+		return models.RunningQuery{}, false
+	}
+
+	q.mutex.Lock()
+	q.cancelled = true
+	q.mutex.Unlock()
+	q.cancel()
+	return q.info(), true
+}
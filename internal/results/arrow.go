@@ -0,0 +1,28 @@
+package results
+
+import (
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+// WriteArrow writes the records from reader as an Arrow IPC stream, calling
+// flush after every record batch. It returns the number of rows written.
+func WriteArrow(w io.Writer, reader array.RecordReader, flush func()) (int, error) {
+	iw := ipc.NewWriter(w, ipc.WithSchema(reader.Schema()))
+
+	count := 0
+	for reader.Next() {
+		rec := reader.Record()
+		if err := iw.Write(rec); err != nil {
+			return count, err
+		}
+		count += int(rec.NumRows())
+		flush()
+	}
+	if err := reader.Err(); err != nil {
+		return count, err
+	}
+	return count, iw.Close()
+}
@@ -0,0 +1,218 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+// csvWriter writes RFC 4180 style CSV with a configurable delimiter and quote
+// character. Fields are quoted when they contain the delimiter, the quote,
+// a line break or leading/trailing space, or always when QuoteAll is set.
+type csvWriter struct {
+	w         io.Writer
+	buf       []byte
+	delimiter rune
+	quote     rune
+	quoteAll  bool
+	header    bool
+	null      string
+}
+
+func newCSVWriter(w io.Writer, opts *models.CSVOptions) (*csvWriter, error) {
+	cw := &csvWriter{w: w, delimiter: ',', quote: '"', header: true}
+	if opts == nil {
+		return cw, nil
+	}
+
+	if opts.Delimiter != "" {
+		r, err := singleRune("delimiter", opts.Delimiter)
+		if err != nil {
+			return nil, err
+		}
+		cw.delimiter = r
+	}
+	if opts.Quote != "" {
+		r, err := singleRune("quote", opts.Quote)
+		if err != nil {
+			return nil, err
+		}
+		cw.quote = r
+	}
+	if cw.delimiter == cw.quote {
+		return nil, fmt.Errorf("csv delimiter and quote must differ")
+	}
+	cw.quoteAll = opts.QuoteAll
+	if opts.Header != nil {
+		cw.header = *opts.Header
+	}
+	cw.null = opts.Null
+	return cw, nil
+}
+
+func singleRune(name, s string) (rune, error) {
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("csv %s must be a single character", name)
+	}
+	return r, nil
+}
+
+func (w *csvWriter) Begin(schema []models.ColumnSchema) error {
+	if !w.header {
+		return nil
+	}
+	return w.writeRecord(Names(schema), nil)
+}
+
+func (w *csvWriter) Row(values []interface{}) error {
+	fields := make([]string, len(values))
+	nulls := make([]bool, len(values))
+	for i, v := range values {
+		if v == nil {
+			fields[i] = w.null
+			nulls[i] = true
+		} else {
+			fields[i] = formatText(v)
+		}
+	}
+	return w.writeRecord(fields, nulls)
+}
+
+func (w *csvWriter) End(summary Summary) error {
+	return nil
+}
+
+func (w *csvWriter) writeRecord(fields []string, nulls []bool) error {
+	w.buf = w.buf[:0]
+	for i, field := range fields {
+		if i > 0 {
+			w.buf = utf8.AppendRune(w.buf, w.delimiter)
+		}
+		// NULLs are written bare so they stay distinguishable from empty
+		// strings, which are always quoted.
+		if nulls != nil && nulls[i] {
+			w.buf = append(w.buf, field...)
+			continue
+		}
+		if !w.needsQuotes(field, nulls != nil) {
+			w.buf = append(w.buf, field...)
+			continue
+		}
+		w.buf = utf8.AppendRune(w.buf, w.quote)
+		for _, r := range field {
+			if r == w.quote {
+				w.buf = utf8.AppendRune(w.buf, w.quote)
+			}
+			w.buf = utf8.AppendRune(w.buf, r)
+		}
+		w.buf = utf8.AppendRune(w.buf, w.quote)
+	}
+	w.buf = append(w.buf, '\r', '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+func (w *csvWriter) needsQuotes(field string, isRow bool) bool {
+	if w.quoteAll || (isRow && field == "") {
+		return true
+	}
+	if field == "" {
+		return false
+	}
+	if strings.ContainsRune(field, w.delimiter) || strings.ContainsRune(field, w.quote) || strings.ContainsAny(field, "\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	last, _ := utf8.DecodeLastRuneInString(field)
+	return r == ' ' || r == '\t' || last == ' ' || last == '\t'
+}
+
+// tsvWriter writes tab-separated values as defined by IANA: no quoting, with
+// tabs, line breaks and backslashes in fields escaped as \t, \n, \r and \\.
+type tsvWriter struct {
+	w      io.Writer
+	buf    []byte
+	header bool
+	null   string
+}
+
+func newTSVWriter(w io.Writer, opts *models.CSVOptions) *tsvWriter {
+	tw := &tsvWriter{w: w, header: true}
+	if opts != nil {
+		if opts.Header != nil {
+			tw.header = *opts.Header
+		}
+		tw.null = opts.Null
+	}
+	return tw
+}
+
+var tsvEscaper = strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (w *tsvWriter) Begin(schema []models.ColumnSchema) error {
+	if !w.header {
+		return nil
+	}
+	return w.writeRecord(Names(schema))
+}
+
+func (w *tsvWriter) Row(values []interface{}) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			fields[i] = w.null
+		} else {
+			fields[i] = formatText(v)
+		}
+	}
+	return w.writeRecord(fields)
+}
+
+func (w *tsvWriter) End(summary Summary) error {
+	return nil
+}
+
+func (w *tsvWriter) writeRecord(fields []string) error {
+	w.buf = w.buf[:0]
+	for i, field := range fields {
+		if i > 0 {
+			w.buf = append(w.buf, '\t')
+		}
+		w.buf = append(w.buf, tsvEscaper.Replace(field)...)
+	}
+	w.buf = append(w.buf, '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// formatText renders a scanned value for delimited text output.
+func formatText(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		return fmt.Sprint(val)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return val.String()
+	case []interface{}, map[string]interface{}:
+		if b, err := json.Marshal(val); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,268 @@
+package results
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// Encoder converts scanned DuckDB values into JSON-safe values with a stable,
+// documented representation per logical type:
+//
+//	BOOLEAN                          true / false
+//	TINYINT … BIGINT, U* integers    number
+//	HUGEINT                          string, e.g. "170141183460469231731687303715884105727"
+//	FLOAT, DOUBLE                    number; "NaN", "Infinity", "-Infinity" as strings
+//	DECIMAL(p,s)                     string with exactly s fraction digits, e.g. "12.50"
+//	VARCHAR, ENUM                    string
+//	BLOB                             base64 string (RFC 4648, padded)
+//	UUID                             "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx"
+//	DATE                             "2006-01-02"
+//	TIME                             "15:04:05.999999"
+//	TIMETZ                           "15:04:05.999999+00:00" (normalised to UTC)
+//	TIMESTAMP*, TIMESTAMPTZ          RFC 3339 in UTC, e.g. "2006-01-02T15:04:05.999999Z"
+//	INTERVAL                         ISO 8601 duration, e.g. "P1Y2M3DT4H5M6.789S"
+//	LIST, ARRAY                      array
+//	STRUCT                           object
+//	MAP                              object; non-string keys use their encoded text
+//	UNION                            object with the active member, e.g. {"num": 2}
+//	JSON                             the JSON value itself
+//	NULL                             null
+type Encoder struct {
+	types []*logicalType
+}
+
+// NewEncoder builds an encoder for the columns of a result set.
+func NewEncoder(columnTypes []*sql.ColumnType) *Encoder {
+	types := make([]*logicalType, len(columnTypes))
+	for i, ct := range columnTypes {
+		types[i] = parseType(ct.DatabaseTypeName())
+	}
+	return &Encoder{types: types}
+}
+
+// Row encodes a scanned row in place.
+func (e *Encoder) Row(values []interface{}) {
+	for i, v := range values {
+		var t *logicalType
+		if i < len(e.types) {
+			t = e.types[i]
+		}
+		values[i] = encodeValue(t, v)
+	}
+}
+
+// EncodeValue encodes a single value of the named DuckDB type.
+func EncodeValue(typeName string, v interface{}) interface{} {
+	return encodeValue(parseType(typeName), v)
+}
+
+func encodeValue(t *logicalType, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	id := ""
+	if t != nil {
+		id = t.id
+	}
+
+	switch id {
+	case "UUID":
+		if s, ok := formatUUID(v); ok {
+			return s
+		}
+	case "DATE":
+		if ts, ok := v.(time.Time); ok {
+			return ts.Format("2006-01-02")
+		}
+	case "TIME":
+		if ts, ok := v.(time.Time); ok {
+			return ts.Format("15:04:05.999999")
+		}
+	case "TIMETZ":
+		if ts, ok := v.(time.Time); ok {
+			return ts.UTC().Format("15:04:05.999999-07:00")
+		}
+	case "LIST", "ARRAY":
+		if list, ok := v.([]interface{}); ok {
+			out := make([]interface{}, len(list))
+			for i, elem := range list {
+				out[i] = encodeValue(t.elem, elem)
+			}
+			return out
+		}
+	case "STRUCT":
+		if m, ok := v.(map[string]interface{}); ok {
+			out := make(map[string]interface{}, len(m))
+			for k, val := range m {
+				out[k] = encodeValue(t.field(k), val)
+			}
+			return out
+		}
+	case "MAP":
+		if m, ok := v.(duckdb.Map); ok {
+			out := make(map[string]interface{}, len(m))
+			for k, val := range m {
+				out[mapKey(encodeValue(t.key, k))] = encodeValue(t.value, val)
+			}
+			return out
+		}
+	case "UNION":
+		if u, ok := v.(duckdb.Union); ok {
+			return map[string]interface{}{u.Tag: encodeValue(t.field(u.Tag), u.Value)}
+		}
+	}
+	return encodeGo(v)
+}
+
+// encodeGo encodes a value by its Go type, for types whose representation
+// does not depend on the DuckDB type name.
+func encodeGo(v interface{}) interface{} {
+	switch val := v.(type) {
+	case float32:
+		return encodeFloat(float64(val), val)
+	case float64:
+		return encodeFloat(val, val)
+	case *big.Int:
+		return val.String()
+	case duckdb.Decimal:
+		return formatDecimal(val)
+	case duckdb.Interval:
+		return formatInterval(val)
+	case duckdb.UUID:
+		return uuid.UUID(val).String()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val)
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, elem := range val {
+			out[i] = encodeGo(elem)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, elem := range val {
+			out[k] = encodeGo(elem)
+		}
+		return out
+	case duckdb.Map:
+		out := make(map[string]interface{}, len(val))
+		for k, elem := range val {
+			out[mapKey(encodeGo(k))] = encodeGo(elem)
+		}
+		return out
+	case duckdb.Union:
+		return map[string]interface{}{val.Tag: encodeGo(val.Value)}
+	}
+	return v
+}
+
+func encodeFloat(f float64, original interface{}) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return original
+}
+
+func formatUUID(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case []byte:
+		if u, err := uuid.FromBytes(val); err == nil {
+			return u.String(), true
+		}
+	case duckdb.UUID:
+		return uuid.UUID(val).String(), true
+	case string:
+		return val, true
+	}
+	return "", false
+}
+
+// formatDecimal renders a decimal with exactly Scale fraction digits.
+func formatDecimal(d duckdb.Decimal) string {
+	if d.Value == nil {
+		return "0"
+	}
+	digits := new(big.Int).Abs(d.Value).String()
+	scale := int(d.Scale)
+	if scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if d.Value.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// formatInterval renders an interval as an ISO 8601 duration. DuckDB keeps
+// months, days and microseconds separately, so each component carries its
+// own sign.
+func formatInterval(iv duckdb.Interval) string {
+	var b strings.Builder
+	b.WriteByte('P')
+	if years := iv.Months / 12; years != 0 {
+		fmt.Fprintf(&b, "%dY", years)
+	}
+	if months := iv.Months % 12; months != 0 {
+		fmt.Fprintf(&b, "%dM", months)
+	}
+	if iv.Days != 0 {
+		fmt.Fprintf(&b, "%dD", iv.Days)
+	}
+
+	micros := iv.Micros
+	if micros != 0 {
+		b.WriteByte('T')
+		hours := micros / int64(time.Hour/time.Microsecond)
+		micros -= hours * int64(time.Hour/time.Microsecond)
+		minutes := micros / int64(time.Minute/time.Microsecond)
+		micros -= minutes * int64(time.Minute/time.Microsecond)
+		if hours != 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+		}
+		if minutes != 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+		}
+		if micros != 0 {
+			sign := ""
+			if micros < 0 {
+				sign, micros = "-", -micros
+			}
+			seconds := strconv.FormatInt(micros/1e6, 10)
+			if frac := micros % 1e6; frac != 0 {
+				seconds += "." + strings.TrimRight(fmt.Sprintf("%06d", frac), "0")
+			}
+			fmt.Fprintf(&b, "%s%sS", sign, seconds)
+		}
+	}
+
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
+
+func mapKey(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,37 @@
+package results
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+// ndjsonWriter emits a header line with the columns and schema, one JSON array per row
+// and a trailer line with the row count, execution time and any error.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *ndjsonWriter) Begin(schema []models.ColumnSchema) error {
+	return w.enc.Encode(models.StreamHeader{Columns: Names(schema), Schema: schema})
+}
+
+func (w *ndjsonWriter) Row(values []interface{}) error {
+	return w.enc.Encode(values)
+}
+
+func (w *ndjsonWriter) End(summary Summary) error {
+	trailer := models.StreamTrailer{
+		Count: summary.Count,
+		Time:  summary.Elapsed.String(),
+	}
+	if summary.Err != nil {
+		trailer.Error = summary.Err.Error()
+	}
+	return w.enc.Encode(trailer)
+}
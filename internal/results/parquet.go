@@ -0,0 +1,68 @@
+package results
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+// parquetRowGroupSize matches DuckDB's own row group size. Rows are buffered
+// in memory until a row group is complete and then written to the client.
+const parquetRowGroupSize = 122880
+
+var parquetCodecs = map[string]compress.Compression{
+	"uncompressed": compress.Codecs.Uncompressed,
+	"snappy":       compress.Codecs.Snappy,
+	"gzip":         compress.Codecs.Gzip,
+	"zstd":         compress.Codecs.Zstd,
+	"brotli":       compress.Codecs.Brotli,
+	"lz4_raw":      compress.Codecs.Lz4Raw,
+}
+
+// ParquetProperties validates the requested Parquet options and returns the
+// writer properties for them. opts may be nil; the default codec is snappy.
+func ParquetProperties(opts *models.ParquetOptions) (*parquet.WriterProperties, error) {
+	codec := compress.Codecs.Snappy
+	if opts != nil && opts.Compression != "" {
+		c, ok := parquetCodecs[strings.ToLower(opts.Compression)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported parquet compression %q", opts.Compression)
+		}
+		codec = c
+	}
+
+	return parquet.NewWriterProperties(
+		parquet.WithCompression(codec),
+		parquet.WithMaxRowGroupLength(parquetRowGroupSize),
+	), nil
+}
+
+// WriteParquet writes the records from reader as a Parquet file, calling
+// flush after every record batch. It returns the number of rows written.
+func WriteParquet(w io.Writer, reader array.RecordReader, props *parquet.WriterProperties, flush func()) (int, error) {
+	fw, err := pqarrow.NewFileWriter(reader.Schema(), w, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for reader.Next() {
+		rec := reader.Record()
+		if err := fw.WriteBuffered(rec); err != nil {
+			return count, err
+		}
+		count += int(rec.NumRows())
+		flush()
+	}
+	if err := reader.Err(); err != nil {
+		return count, err
+	}
+	return count, fw.Close()
+}
@@ -0,0 +1,119 @@
+package results
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+type Format string
+
+const (
+	FormatJSON    Format = "json"
+	FormatNDJSON  Format = "ndjson"
+	FormatArrow   Format = "arrow"
+	FormatCSV     Format = "csv"
+	FormatTSV     Format = "tsv"
+	FormatParquet Format = "parquet"
+)
+
+var contentTypes = map[Format]string{
+	FormatJSON:    "application/json",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatArrow:   "application/vnd.apache.arrow.stream",
+	FormatCSV:     "text/csv",
+	FormatTSV:     "text/tab-separated-values",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// extensions lists the formats served as file downloads.
+var extensions = map[Format]string{
+	FormatCSV:     ".csv",
+	FormatTSV:     ".tsv",
+	FormatParquet: ".parquet",
+}
+
+func (f Format) ContentType() string {
+	return contentTypes[f]
+}
+
+// Extension returns the file extension for download formats, or "" for
+// formats that are not served as attachments.
+func (f Format) Extension() string {
+	return extensions[f]
+}
+
+// Columnar reports whether the format is written from Arrow record batches
+// rather than scanned rows.
+func (f Format) Columnar() bool {
+	return f == FormatArrow || f == FormatParquet
+}
+
+// HasTrailer reports whether the format can carry an error after rows have
+// been written. Streams in other formats must be aborted instead.
+func (f Format) HasTrailer() bool {
+	return f == FormatNDJSON
+}
+
+// Streaming reports whether rows are written as they are scanned rather than
+// buffered into a single response document.
+func (f Format) Streaming() bool {
+	return f != FormatJSON
+}
+
+// Negotiate picks the response format. An explicitly requested format name
+// wins; otherwise the first supported media type in the Accept header is
+// used, falling back to JSON.
+func Negotiate(requested, accept string) (Format, error) {
+	if requested != "" {
+		f := Format(strings.ToLower(requested))
+		if _, ok := contentTypes[f]; !ok {
+			return "", fmt.Errorf("unsupported format %q", requested)
+		}
+		return f, nil
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for f, ct := range contentTypes {
+			if mediaType == ct {
+				return f, nil
+			}
+		}
+	}
+	return FormatJSON, nil
+}
+
+// Summary describes a finished result set.
+type Summary struct {
+	Count   int
+	Elapsed time.Duration
+	Err     error
+}
+
+// Writer encodes a result set incrementally.
+type Writer interface {
+	Begin(schema []models.ColumnSchema) error
+	Row(values []interface{}) error
+	End(summary Summary) error
+}
+
+// NewWriter returns a Writer for a row-streaming format. csv may be nil.
+func NewWriter(f Format, w io.Writer, csv *models.CSVOptions) (Writer, error) {
+	switch f {
+	case FormatNDJSON:
+		return newNDJSONWriter(w), nil
+	case FormatCSV:
+		return newCSVWriter(w, csv)
+	case FormatTSV:
+		return newTSVWriter(w, csv), nil
+	}
+	return nil, fmt.Errorf("format %q does not support row streaming", f)
+}
@@ -0,0 +1,43 @@
+package results
+
+import (
+	"database/sql"
+
+	"github.com/lab1702/goduck/pkg/models"
+)
+
+// Schema describes the columns of a result set. Precision and scale are set
+// for DECIMAL columns only. DuckDB does not report whether a result column
+// can hold NULL, so columns are nullable unless the driver says otherwise.
+func Schema(columnTypes []*sql.ColumnType) []models.ColumnSchema {
+	schema := make([]models.ColumnSchema, len(columnTypes))
+	for i, ct := range columnTypes {
+		col := models.ColumnSchema{
+			Name:     ct.Name(),
+			Type:     ct.DatabaseTypeName(),
+			Nullable: true,
+		}
+		if nullable, ok := ct.Nullable(); ok {
+			col.Nullable = nullable
+		}
+
+		if precision, scale, ok := ct.DecimalSize(); ok {
+			p, s := int(precision), int(scale)
+			col.Precision, col.Scale = &p, &s
+		} else if t := parseType(col.Type); t.id == "DECIMAL" {
+			p, s := t.precision, t.scale
+			col.Precision, col.Scale = &p, &s
+		}
+		schema[i] = col
+	}
+	return schema
+}
+
+// Names returns the column names of a schema.
+func Names(schema []models.ColumnSchema) []string {
+	names := make([]string, len(schema))
+	for i, col := range schema {
+		names[i] = col.Name
+	}
+	return names
+}
@@ -0,0 +1,211 @@
+package results
+
+import (
+	"strconv"
+	"strings"
+)
+
+// logicalType is a parsed DuckDB type name such as "DECIMAL(10,2)",
+// "INTEGER[]" or `STRUCT("a" INTEGER, "b" MAP(VARCHAR, DATE))`.
+type logicalType struct {
+	id        string
+	precision int
+	scale     int
+	elem      *logicalType // LIST and ARRAY
+	key       *logicalType // MAP
+	value     *logicalType // MAP
+	fields    []field      // STRUCT and UNION
+}
+
+type field struct {
+	name string
+	typ  *logicalType
+}
+
+func (t *logicalType) field(name string) *logicalType {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f.typ
+		}
+	}
+	return nil
+}
+
+// parseType parses a DuckDB type name as reported by ColumnType.DatabaseTypeName.
+// Unrecognised syntax yields a type with only its id set, which the encoder
+// handles by Go type alone.
+func parseType(s string) *logicalType {
+	p := &typeParser{s: s}
+	t := p.parse()
+	if t == nil {
+		return &logicalType{id: strings.ToUpper(strings.TrimSpace(s))}
+	}
+	return t
+}
+
+type typeParser struct {
+	s   string
+	pos int
+}
+
+func (p *typeParser) parse() *logicalType {
+	p.skipSpace()
+	name := p.ident()
+	if name == "" {
+		return nil
+	}
+
+	t := &logicalType{id: strings.ToUpper(name)}
+	if p.peek() == '(' {
+		p.pos++
+		switch t.id {
+		case "DECIMAL", "NUMERIC":
+			t.id = "DECIMAL"
+			t.precision = p.number()
+			if p.consume(',') {
+				t.scale = p.number()
+			}
+		case "STRUCT", "UNION":
+			for {
+				p.skipSpace()
+				name := p.fieldName()
+				typ := p.parse()
+				if typ == nil {
+					return nil
+				}
+				t.fields = append(t.fields, field{name: name, typ: typ})
+				if !p.consume(',') {
+					break
+				}
+			}
+		case "MAP":
+			if t.key = p.parse(); t.key == nil || !p.consume(',') {
+				return nil
+			}
+			if t.value = p.parse(); t.value == nil {
+				return nil
+			}
+		default:
+			// Parameters we do not interpret, e.g. ENUM('a', 'b').
+			p.skipParens()
+			return p.suffix(t)
+		}
+		if !p.consume(')') {
+			return nil
+		}
+	}
+	return p.suffix(t)
+}
+
+// suffix wraps t in LIST and ARRAY types for trailing [] and [N].
+func (p *typeParser) suffix(t *logicalType) *logicalType {
+	for {
+		p.skipSpace()
+		if p.peek() != '[' {
+			return t
+		}
+		p.pos++
+		if p.consume(']') {
+			t = &logicalType{id: "LIST", elem: t}
+			continue
+		}
+		p.number()
+		if !p.consume(']') {
+			return nil
+		}
+		t = &logicalType{id: "ARRAY", elem: t}
+	}
+}
+
+// ident reads a possibly multi-word type name such as "TIMESTAMP WITH TIME ZONE".
+func (p *typeParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune("(),[]\"", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	name := strings.Join(strings.Fields(p.s[start:p.pos]), " ")
+	switch strings.ToUpper(name) {
+	case "TIMESTAMP WITH TIME ZONE":
+		return "TIMESTAMPTZ"
+	case "TIME WITH TIME ZONE":
+		return "TIMETZ"
+	}
+	return name
+}
+
+// fieldName reads a STRUCT or UNION member name, quoted or bare.
+func (p *typeParser) fieldName() string {
+	if p.peek() == '"' {
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.s) {
+			c := p.s[p.pos]
+			p.pos++
+			if c == '"' {
+				if p.peek() == '"' {
+					p.pos++
+					b.WriteByte('"')
+					continue
+				}
+				break
+			}
+			b.WriteByte(c)
+		}
+		return b.String()
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != ' ' {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *typeParser) number() int {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, _ := strconv.Atoi(p.s[start:p.pos])
+	return n
+}
+
+func (p *typeParser) skipParens() {
+	depth := 1
+	for p.pos < len(p.s) && depth > 0 {
+		switch p.s[p.pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '\'':
+			if end := strings.IndexByte(p.s[p.pos+1:], '\''); end >= 0 {
+				p.pos += end + 1
+			}
+		}
+		p.pos++
+	}
+}
+
+func (p *typeParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *typeParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *typeParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
@@ -0,0 +1,295 @@
+package statements
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lab1702/goduck/internal/database"
+
+	"github.com/marcboeker/go-duckdb/mapping"
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// Class groups DuckDB statement types for policy decisions.
+type Class string
+
+const (
+	ClassSelect      Class = "select"      // SELECT, FROM, VALUES, DESCRIBE, SHOW, SUMMARIZE, PIVOT ... IN, UNPIVOT, most PRAGMAs
+	ClassExplain     Class = "explain"     // EXPLAIN, EXPLAIN ANALYZE
+	ClassDML         Class = "dml"         // INSERT, UPDATE, DELETE
+	ClassDDL         Class = "ddl"         // CREATE, ALTER, DROP
+	ClassAttach      Class = "attach"      // ATTACH, DETACH
+	ClassCopy        Class = "copy"        // COPY, EXPORT DATABASE
+	ClassSet         Class = "set"         // SET, RESET, USE, PRAGMA name=value
+	ClassPragma      Class = "pragma"      // PRAGMA statements that are not queries or settings
+	ClassExtension   Class = "extension"   // INSTALL, LOAD
+	ClassTransaction Class = "transaction" // BEGIN, COMMIT, ROLLBACK
+	ClassPrepare     Class = "prepare"     // PREPARE, EXECUTE
+	ClassCall        Class = "call"        // CALL, CHECKPOINT
+	ClassMaintenance Class = "maintenance" // VACUUM, ANALYZE
+	ClassMulti       Class = "multi"       // several statements, not all SELECT, or PIVOT without IN
+	ClassOther       Class = "other"       // anything else
+)
+
+var classes = []Class{
+	ClassSelect, ClassExplain, ClassDML, ClassDDL, ClassAttach, ClassCopy, ClassSet, ClassPragma,
+	ClassExtension, ClassTransaction, ClassPrepare, ClassCall, ClassMaintenance, ClassMulti, ClassOther,
+}
+
+var statementClasses = map[duckdb.StmtType]Class{
+	duckdb.STATEMENT_TYPE_SELECT:       ClassSelect,
+	duckdb.STATEMENT_TYPE_RELATION:     ClassSelect,
+	duckdb.STATEMENT_TYPE_EXPLAIN:      ClassExplain,
+	duckdb.STATEMENT_TYPE_INSERT:       ClassDML,
+	duckdb.STATEMENT_TYPE_UPDATE:       ClassDML,
+	duckdb.STATEMENT_TYPE_DELETE:       ClassDML,
+	duckdb.STATEMENT_TYPE_CREATE:       ClassDDL,
+	duckdb.STATEMENT_TYPE_CREATE_FUNC:  ClassDDL,
+	duckdb.STATEMENT_TYPE_ALTER:        ClassDDL,
+	duckdb.STATEMENT_TYPE_DROP:         ClassDDL,
+	duckdb.STATEMENT_TYPE_ATTACH:       ClassAttach,
+	duckdb.STATEMENT_TYPE_DETACH:       ClassAttach,
+	duckdb.STATEMENT_TYPE_COPY:         ClassCopy,
+	duckdb.STATEMENT_TYPE_EXPORT:       ClassCopy,
+	duckdb.STATEMENT_TYPE_SET:          ClassSet,
+	duckdb.STATEMENT_TYPE_VARIABLE_SET: ClassSet,
+	duckdb.STATEMENT_TYPE_PRAGMA:       ClassPragma,
+	duckdb.STATEMENT_TYPE_LOAD:         ClassExtension,
+	duckdb.STATEMENT_TYPE_EXTENSION:    ClassExtension,
+	duckdb.STATEMENT_TYPE_TRANSACTION:  ClassTransaction,
+	duckdb.STATEMENT_TYPE_PREPARE:      ClassPrepare,
+	duckdb.STATEMENT_TYPE_EXECUTE:      ClassPrepare,
+	duckdb.STATEMENT_TYPE_CALL:         ClassCall,
+	duckdb.STATEMENT_TYPE_VACUUM:       ClassMaintenance,
+	duckdb.STATEMENT_TYPE_ANALYZE:      ClassMaintenance,
+	duckdb.STATEMENT_TYPE_MULTI:        ClassMulti,
+}
+
+// ParseClasses parses a comma-separated list of classes. "all" stands for
+// every class.
+func ParseClasses(s string) ([]Class, error) {
+	var list []Class
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "":
+			continue
+		case name == "all":
+			return classes, nil
+		case !knownClass(Class(name)):
+			return nil, fmt.Errorf("unknown statement class %q", name)
+		}
+		list = append(list, Class(name))
+	}
+	return list, nil
+}
+
+func knownClass(c Class) bool {
+	for _, known := range classes {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// parserSettings keep the classifier's own DuckDB instances small and unable
+// to reach outside the process.
+const parserSettings = "threads=1&enable_external_access=false"
+
+// Classifier determines the class of a query using DuckDB's own parser,
+// without executing it.
+type Classifier struct {
+	db *database.DB
+
+	// serializer and splitter are separate, empty DuckDB instances, so that
+	// parsing never waits for a connection of the query pool. serializer
+	// runs json_serialize_sql; splitter splits queries into statements.
+	serializer *sql.DB
+	splitter   mapping.Database
+}
+
+func NewClassifier(db *database.DB) (*Classifier, error) {
+	connector, err := duckdb.NewConnector("?"+parserSettings, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open statement parser: %w", err)
+	}
+
+	var config mapping.Config
+	if mapping.CreateConfig(&config) == mapping.StateError {
+		return nil, errors.New("failed to configure statement parser")
+	}
+	defer mapping.DestroyConfig(&config)
+	for _, setting := range strings.Split(parserSettings, "&") {
+		name, value, _ := strings.Cut(setting, "=")
+		mapping.SetConfig(config, name, value)
+	}
+
+	c := &Classifier{db: db, serializer: sql.OpenDB(connector)}
+	var errMsg string
+	if mapping.OpenExt("", &c.splitter, config, &errMsg) == mapping.StateError {
+		c.serializer.Close()
+		return nil, fmt.Errorf("failed to open statement parser: %s", errMsg)
+	}
+	return c, nil
+}
+
+func (c *Classifier) Close() {
+	c.serializer.Close()
+	mapping.Close(&c.splitter)
+}
+
+// serializedSQL is the output of json_serialize_sql.
+type serializedSQL struct {
+	Error        bool              `json:"error"`
+	ErrorType    string            `json:"error_type"`
+	ErrorMessage string            `json:"error_message"`
+	Statements   []json.RawMessage `json:"statements"`
+}
+
+// Classify returns the class of query. Queries made up only of SELECT
+// statements are recognized by json_serialize_sql, which parses without
+// binding. Otherwise a single statement is prepared, but not executed, to
+// learn its type; several statements are classified as ClassMulti because
+// the driver would run all but the last of them while preparing.
+func (c *Classifier) Classify(ctx context.Context, query string) (Class, error) {
+	var out string
+	literal := "'" + strings.ReplaceAll(query, "'", "''") + "'"
+	if err := c.serializer.QueryRowContext(ctx, "SELECT json_serialize_sql("+literal+")::VARCHAR").Scan(&out); err != nil {
+		return "", err
+	}
+
+	var serialized serializedSQL
+	if err := json.Unmarshal([]byte(out), &serialized); err != nil {
+		return "", err
+	}
+	if !serialized.Error {
+		if len(serialized.Statements) == 0 {
+			return "", errors.New("no statements in query")
+		}
+		return ClassSelect, nil
+	}
+	if serialized.ErrorType == "parser" {
+		return "", fmt.Errorf("parser error: %s", serialized.ErrorMessage)
+	}
+
+	count, err := c.countStatements(query)
+	if err != nil {
+		return "", err
+	}
+	if count > 1 {
+		return ClassMulti, nil
+	}
+
+	stmtType, err := c.db.StatementType(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	if stmtType == duckdb.STATEMENT_TYPE_EXPLAIN {
+		return c.classifyExplain(ctx, query)
+	}
+	if class, ok := statementClasses[stmtType]; ok {
+		return class, nil
+	}
+	return ClassOther, nil
+}
+
+var (
+	plainExplain   = regexp.MustCompile(`(?is)^\s*explain\s+`)
+	explainOptions = regexp.MustCompile(`(?is)^\s*explain\s+(?:analy[sz]e\b|\([^)]*\))(.*)$`)
+)
+
+// classifyExplain classifies an EXPLAIN statement. EXPLAIN ANALYZE runs the
+// explained statement, so with ANALYZE or other options it is classified as
+// the statement it wraps, unless that is a query. An EXPLAIN whose form is
+// not recognized, e.g. because of a leading comment, is ClassOther.
+func (c *Classifier) classifyExplain(ctx context.Context, query string) (Class, error) {
+	if m := explainOptions.FindStringSubmatch(query); m != nil {
+		class, err := c.Classify(ctx, m[1])
+		if err != nil || class != ClassSelect {
+			return class, err
+		}
+		return ClassExplain, nil
+	}
+	if plainExplain.MatchString(query) {
+		return ClassExplain, nil
+	}
+	return ClassOther, nil
+}
+
+// ReadOnly reports whether statements of the class only read data.
+func (c Class) ReadOnly() bool {
+	return c == ClassSelect || c == ClassExplain
+}
+
+func (c *Classifier) countStatements(query string) (int, error) {
+	var conn mapping.Connection
+	if mapping.Connect(c.splitter, &conn) == mapping.StateError {
+		return 0, errors.New("failed to connect to statement parser")
+	}
+	defer mapping.Disconnect(&conn)
+
+	var stmts mapping.ExtractedStatements
+	count := mapping.ExtractStatements(conn, query, &stmts)
+	defer mapping.DestroyExtracted(&stmts)
+	if count == 0 {
+		if msg := mapping.ExtractStatementsError(stmts); msg != "" {
+			return 0, errors.New(msg)
+		}
+		return 0, errors.New("no statements in query")
+	}
+	return int(count), nil
+}
+
+// NotAllowedError reports a query whose class the policy does not allow.
+type NotAllowedError struct {
+	Class Class
+}
+
+func (e *NotAllowedError) Error() string {
+	return fmt.Sprintf("%s statements are not allowed", e.Class)
+}
+
+// Policy allows or rejects queries by class.
+type Policy struct {
+	classifier *Classifier
+	allowed    map[Class]bool
+}
+
+func NewPolicy(classifier *Classifier, allowed []Class) *Policy {
+	p := &Policy{classifier: classifier, allowed: make(map[Class]bool)}
+	for _, c := range allowed {
+		p.allowed[c] = true
+	}
+	return p
+}
+
+// Allowed returns the allowed classes in a stable order.
+func (p *Policy) Allowed() []Class {
+	list := make([]Class, 0, len(p.allowed))
+	for c := range p.allowed {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// Check classifies query and returns a *NotAllowedError if its class is not
+// allowed. Other errors mean the query could not be classified, typically
+// because it is invalid.
+func (p *Policy) Check(ctx context.Context, query string) (Class, error) {
+	class, err := p.classifier.Classify(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	if !p.allowed[class] {
+		return class, &NotAllowedError{Class: class}
+	}
+	return class, nil
+}
@@ -0,0 +1,163 @@
+// Package tlsconfig serves TLS certificates that are reloaded when their
+// files change.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options configure a Reloader.
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, if set, is a PEM bundle client certificates are verified
+	// against, as ClientAuth requires.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+	MinVersion   uint16
+	// The files are checked for changes at most once per ReloadInterval.
+	ReloadInterval time.Duration
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader holds the TLS configuration built from its files. New handshakes
+// use the files' current contents: a changed certificate, key or CA bundle
+// is picked up without a restart, and one that fails to load is logged and
+// the previous configuration kept.
+type Reloader struct {
+	options Options
+
+	mutex     sync.Mutex
+	config    *tls.Config
+	stamps    []stamp
+	lastCheck time.Time
+}
+
+// NewReloader loads the files in options, failing if they are invalid.
+func NewReloader(options Options) (*Reloader, error) {
+	r := &Reloader{options: options}
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	config, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.config, r.stamps, r.lastCheck = config, stamps, time.Now()
+	return r, nil
+}
+
+// Config returns the server configuration, which hands each handshake the
+// configuration built from the current files.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.options.MinVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// current returns the configuration, first reloading it if a file changed.
+func (r *Reloader) current() *tls.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.lastCheck) < r.options.ReloadInterval {
+		return r.config
+	}
+	r.lastCheck = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to check TLS files, keeping the current certificate")
+		return r.config
+	}
+	if equalStamps(stamps, r.stamps) {
+		return r.config
+	}
+
+	config, err := r.load()
+	if err != nil {
+		// The files may be half-written; try again once they change.
+		logrus.WithError(err).Warn("Failed to reload TLS files, keeping the current certificate")
+		r.stamps = stamps
+		return r.config
+	}
+	logrus.WithField("cert_file", r.options.CertFile).Info("Reloaded TLS certificate")
+	r.config, r.stamps = config, stamps
+	return r.config
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.options.CertFile, r.options.KeyFile}
+	if r.options.ClientCAFile != "" {
+		files = append(files, r.options.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) stat() ([]stamp, error) {
+	var stamps []stamp
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, stamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   r.options.MinVersion,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if r.options.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("loading client CAs: no certificates found")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = r.options.ClientAuth
+	}
+	return config, nil
+}
+
+func equalStamps(a, b []stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+// Package tracing sets up OpenTelemetry tracing and starts the spans of a
+// request.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Span attributes.
+const (
+	RequestIDKey = attribute.Key("goduck.request_id")
+	PrincipalKey = attribute.Key("goduck.principal")
+	ClassKey     = attribute.Key("goduck.statement_class")
+	RowsKey      = attribute.Key("goduck.rows")
+	JobIDKey     = attribute.Key("goduck.job_id")
+)
+
+const tracerName = "github.com/lab1702/goduck"
+
+// Config selects where spans are exported.
+type Config struct {
+	// Exporter is one of the Exporter constants. The OTLP exporter sends
+	// spans over HTTP and is configured with the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	Exporter string
+	// File receives the spans, one JSON object per line, for ExporterFile.
+	File string
+	// SampleRatio is the fraction of new traces sampled. Requests carrying
+	// a traceparent header follow the caller's sampling decision.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	var file io.Closer
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		var f *os.File
+		if f, err = os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+			return nil, err
+		}
+		file = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("goduck")))
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	if env, err := resource.New(ctx, resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, env); err == nil {
+			res = merged
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose spans are tagged with requestID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID of ctx, if any.
+func RequestID(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok
+}
+
+// Start starts a span as a child of the span in ctx, tagged with the
+// request ID of ctx. Without a configured tracer provider the span does
+// nothing.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if requestID, ok := RequestID(ctx); ok {
+		opts = append(opts, trace.WithAttributes(RequestIDKey.String(requestID)))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End records err, if not nil, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartQuery starts the span of running sql on DuckDB.
+func StartQuery(ctx context.Context, sql string) (context.Context, trace.Span) {
+	return Start(ctx, "duckdb.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("duckdb"), semconv.DBQueryText(sql)),
+	)
+}
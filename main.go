@@ -2,16 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/lab1702/goduck/internal/access"
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/clientip"
 	"github.com/lab1702/goduck/internal/config"
+	"github.com/lab1702/goduck/internal/cursor"
 	"github.com/lab1702/goduck/internal/database"
 	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/metrics"
 	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/internal/statements"
+	"github.com/lab1702/goduck/internal/tlsconfig"
+	"github.com/lab1702/goduck/internal/tracing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -32,29 +45,143 @@ func main() {
 
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 
-	db, err := database.NewDB(cfg.DatabasePath, cfg.MaxConnections, cfg.ReadWrite)
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.TracingExporter != tracing.ExporterNone {
+		shutdownTracing, err = tracing.Setup(context.Background(), tracing.Config{
+			Exporter:    cfg.TracingExporter,
+			File:        cfg.TracingFile,
+			SampleRatio: cfg.TracingSampleRatio,
+		})
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to set up tracing")
+		}
+	}
+
+	var sandbox *database.Sandbox
+	if cfg.Sandbox {
+		sandbox = &database.Sandbox{
+			AllowedDirectories: cfg.AllowedDirectories,
+			AllowedPaths:       cfg.AllowedPaths,
+		}
+	}
+
+	resources := &database.Resources{
+		MemoryLimit:          cfg.MemoryLimit,
+		Threads:              cfg.Threads,
+		TempDirectory:        cfg.TempDirectory,
+		MaxTempDirectorySize: cfg.MaxTempDirectorySize,
+	}
+
+	db, err := database.NewDB(cfg.DatabasePath, cfg.MaxConnections, cfg.ReadWrite, resources, sandbox)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to initialize database")
 	}
 	defer db.Close()
 
-	queryHandler := handlers.NewQueryHandler(db, cfg.QueryTimeout)
+	classifier, err := statements.NewClassifier(db)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to initialize statement classifier")
+	}
+	defer classifier.Close()
 
-	// Rate limiter: 60 requests per minute per IP
-	rateLimiter := middleware.NewRateLimiter(60)
+	// Validate has already checked the list.
+	allowed, _ := statements.ParseClasses(cfg.AllowedStatements)
+	policy := statements.NewPolicy(classifier, allowed)
+
+	cursors := cursor.NewStore(cfg.CursorTTL, cfg.MaxCursors, cfg.MaxCursorRows, cfg.MaxCursorTotalRows)
+	defer cursors.Close()
+	running := registry.New()
+	var enforcer *access.Enforcer
+	if cfg.AccessPolicyFile != "" {
+		accessPolicy, err := access.LoadPolicy(cfg.AccessPolicyFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to load access policy")
+		}
+		enforcer = access.NewEnforcer(db, accessPolicy)
+	}
+
+	var auditLog *audit.Log
+	if cfg.AuditLogFile != "" {
+		auditLog, err = audit.Open(cfg.AuditLogFile, int64(cfg.AuditMaxSizeMB)<<20, cfg.AuditMaxFiles)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to open audit log")
+		}
+		defer auditLog.Close()
+	}
+
+	var controller *admission.Controller
+	if cfg.MaxConcurrentQueries > 0 {
+		controller = admission.NewController(admission.Config{
+			MaxConcurrent:   cfg.MaxConcurrentQueries,
+			QueueSize:       cfg.QueryQueueSize,
+			QueueTimeout:    cfg.QueryQueueTimeout,
+			BatchPrincipals: cfg.BatchPrincipals,
+			RoleLimits:      cfg.RoleQueryLimits,
+		})
+	}
+
+	queryHandler := handlers.NewQueryHandler(db, cfg.QueryTimeout, cursors, running, policy, enforcer, controller)
+	adminHandler := handlers.NewAdminHandler(running, auditLog)
+
+	jobManager := jobs.NewManager(db, jobs.Config{
+		Workers:   cfg.JobWorkers,
+		QueueSize: cfg.JobQueueSize,
+		MaxJobs:   cfg.MaxJobs,
+		Timeout:   cfg.JobTimeout,
+		ResultTTL: cfg.JobResultTTL,
+		Admission: controller,
+	})
+	defer jobManager.Close()
+	jobHandler := handlers.NewJobHandler(jobManager, queryHandler)
+
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit, cfg.RateLimitRoutes)
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
+	// Client IPs are resolved by ClientIPMiddleware instead, which also
+	// understands the Forwarded header.
+	if err := router.SetTrustedProxies(nil); err != nil {
+		logrus.WithError(err).Fatal("Failed to configure trusted proxies")
+	}
 
+	router.Use(middleware.ClientIPMiddleware(clientip.NewResolver(cfg.TrustedProxies, cfg.ClientIPHeader)))
 	router.Use(middleware.RequestIDMiddleware())
+	if cfg.TracingExporter != tracing.ExporterNone {
+		router.Use(middleware.TracingMiddleware())
+	}
 	router.Use(middleware.RecoveryMiddleware())
 	router.Use(middleware.LoggingMiddleware())
+	router.Use(middleware.MetricsMiddleware())
+	if len(cfg.IPAllow) > 0 || len(cfg.IPDeny) > 0 {
+		router.Use(middleware.IPFilterMiddleware(cfg.IPAllow, cfg.IPDeny))
+	}
 	router.Use(middleware.CORSMiddleware())
-	router.Use(rateLimiter.Middleware())
 
-	router.POST("/query", queryHandler.ExecuteQuery)
+	// Health checks stay unauthenticated and unlimited for load balancers.
 	router.GET("/health", queryHandler.Health)
-	router.GET("/metrics", queryHandler.Metrics)
+
+	// Requests are limited by client IP until they are authenticated, so
+	// that guessing credentials is limited too, and then by principal.
+	api := router.Group("/")
+	api.Use(rateLimiter.PreAuthMiddleware())
+	if authenticator := newAuthenticator(cfg); authenticator != nil {
+		api.Use(middleware.AuthMiddleware(authenticator))
+	}
+	api.Use(rateLimiter.Middleware())
+	api.Use(middleware.AuditMiddleware(auditLog, metrics.ObserveStatement))
+
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.GET("/metrics", middleware.RequireScope(auth.ScopeMetrics), queryHandler.Metrics)
+
+	api.POST("/jobs", jobHandler.Submit)
+	api.GET("/jobs/:id", middleware.RequireScope(auth.ScopeQueryRead), jobHandler.Status)
+	api.GET("/jobs/:id/result", middleware.RequireScope(auth.ScopeQueryRead), jobHandler.Result)
+	api.DELETE("/jobs/:id", middleware.RequireScope(auth.ScopeQueryRead), jobHandler.Delete)
+
+	admin := api.Group("/admin", middleware.RequireScope(auth.ScopeAdmin))
+	admin.GET("/queries", adminHandler.ListQueries)
+	admin.DELETE("/queries/:request_id", adminHandler.CancelQuery)
+	admin.GET("/audit", adminHandler.ListAudit)
 
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -64,9 +191,27 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	if cfg.TLSEnabled() {
+		reloader, err := tlsconfig.NewReloader(tlsOptions(cfg))
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to load TLS configuration")
+		}
+		srv.TLSConfig = reloader.Config()
+	}
+
 	go func() {
-		logrus.WithField("port", cfg.Port).Info("Starting server")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.WithFields(logrus.Fields{
+			"port": cfg.Port,
+			"tls":  cfg.TLSEnabled(),
+		}).Info("Starting server")
+		var err error
+		if cfg.TLSEnabled() {
+			// The certificate comes from srv.TLSConfig.
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logrus.WithError(err).Fatal("Failed to start server")
 		}
 	}()
@@ -85,4 +230,70 @@ func main() {
 	} else {
 		logrus.Info("Server shutdown complete")
 	}
+
+	// Flush the spans of the last requests.
+	if err := shutdownTracing(ctx); err != nil {
+		logrus.WithError(err).Error("Failed to flush traces")
+	}
+}
+
+// newAuthenticator returns the configured authenticators, or nil if
+// authentication is disabled.
+func newAuthenticator(cfg *config.Config) auth.Authenticator {
+	var chain auth.Chain
+
+	if cfg.APIKeysFile != "" {
+		keys, err := auth.LoadKeys(cfg.APIKeysFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to load API keys")
+		}
+		chain = append(chain, keys)
+	}
+
+	if cfg.JWTEnabled() {
+		jwks := auth.NewJWKSFile(cfg.JWKSFile, cfg.JWKSRefresh)
+		if cfg.JWKSURL != "" {
+			jwks = auth.NewJWKSURL(cfg.JWKSURL, cfg.JWKSRefresh)
+		}
+		if err := jwks.Load(context.Background()); err != nil {
+			logrus.WithError(err).Fatal("Failed to load JWKS")
+		}
+		chain = append(chain, auth.NewJWTValidator(auth.JWTConfig{
+			JWKS:       jwks,
+			Issuer:     cfg.JWTIssuer,
+			Audience:   cfg.JWTAudience,
+			Leeway:     cfg.JWTLeeway,
+			NameClaim:  cfg.JWTNameClaim,
+			RolesClaim: cfg.JWTRolesClaim,
+			RoleScopes: cfg.JWTRoleScopes,
+		}))
+	}
+
+	if cfg.TLSClientCAFile != "" {
+		chain = append(chain, auth.NewCertAuthenticator(cfg.TLSClientRoleScopes))
+	}
+
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// tlsOptions returns the TLS settings of cfg, which Validate has checked.
+func tlsOptions(cfg *config.Config) tlsconfig.Options {
+	options := tlsconfig.Options{
+		CertFile:       cfg.TLSCertFile,
+		KeyFile:        cfg.TLSKeyFile,
+		ClientCAFile:   cfg.TLSClientCAFile,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		MinVersion:     tls.VersionTLS12,
+		ReloadInterval: cfg.TLSReloadInterval,
+	}
+	if cfg.TLSClientAuth == "optional" {
+		options.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if cfg.TLSMinVersion == "1.3" {
+		options.MinVersion = tls.VersionTLS13
+	}
+	return options
 }
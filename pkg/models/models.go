@@ -1,20 +1,118 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type QueryRequest struct {
-	SQL string `json:"sql" binding:"required"`
+	SQL    string          `json:"sql" binding:"required_without=PageToken"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Format string          `json:"format,omitempty"`
+
+	// Limit splits a JSON result into pages of at most Limit rows. The
+	// remaining pages are fetched by sending PageToken, without SQL.
+	Limit     int    `json:"limit,omitempty"`
+	PageToken string `json:"page_token,omitempty"`
+
+	// Filename names downloaded results (CSV, TSV, Parquet); the extension is
+	// added by the server.
+	Filename string          `json:"filename,omitempty"`
+	CSV      *CSVOptions     `json:"csv,omitempty"`
+	Parquet  *ParquetOptions `json:"parquet,omitempty"`
+}
+
+// CSVOptions controls delimited text output. Header and Null also apply to
+// TSV, which always uses tabs and backslash escapes instead of quoting.
+type CSVOptions struct {
+	Delimiter string `json:"delimiter,omitempty"`
+	Quote     string `json:"quote,omitempty"`
+	QuoteAll  bool   `json:"quote_all,omitempty"`
+	Header    *bool  `json:"header,omitempty"`
+	Null      string `json:"null,omitempty"`
+}
+
+type ParquetOptions struct {
+	Compression string `json:"compression,omitempty"`
 }
 
 type QueryResponse struct {
 	Columns []string        `json:"columns"`
+	Schema  []ColumnSchema  `json:"schema"`
 	Rows    [][]interface{} `json:"rows"`
 	Count   int             `json:"count"`
 	Time    string          `json:"execution_time"`
+
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// ColumnSchema describes a result column. Type is the DuckDB type name, e.g.
+// "DECIMAL(10,2)" or "INTEGER[]"; Precision and Scale are set for decimals.
+type ColumnSchema struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Precision *int   `json:"precision,omitempty"`
+	Scale     *int   `json:"scale,omitempty"`
+	Nullable  bool   `json:"nullable"`
 }
 
+type StreamHeader struct {
+	Columns []string       `json:"columns"`
+	Schema  []ColumnSchema `json:"schema"`
+}
+
+type StreamTrailer struct {
+	Count int    `json:"count"`
+	Time  string `json:"execution_time"`
+	Error string `json:"error,omitempty"`
+}
+
+// JobStatus reports the state of an asynchronous query job. Elapsed is the
+// running time so far, or the total once the job has finished; RowCount and
+// ExpiresAt are set once the result is available.
+type JobStatus struct {
+	ID          string     `json:"id"`
+	State       string     `json:"state"`
+	SQL         string     `json:"sql"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Elapsed     string     `json:"elapsed,omitempty"`
+	RowCount    *int64     `json:"row_count,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// RunningQuery describes a query in flight, as listed by GET /admin/queries.
+type RunningQuery struct {
+	RequestID string    `json:"request_id"`
+	SQL       string    `json:"sql"`
+	ClientIP  string    `json:"client_ip"`
+	StartedAt time.Time `json:"started_at"`
+	Elapsed   string    `json:"elapsed"`
+	Cancelled bool      `json:"cancelled"`
+}
+
+// Error codes set in ErrorResponse.Code.
+const (
+	CodeStatementNotAllowed = "statement_not_allowed"
+	CodeUnauthenticated     = "unauthenticated"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeInsufficientScope   = "insufficient_scope"
+	CodeAccessDenied        = "access_denied"
+	CodeRateLimited         = "rate_limited"
+	CodeAddressNotAllowed   = "address_not_allowed"
+	CodeQueueFull           = "queue_full"
+	CodeQueueTimeout        = "queue_timeout"
+	CodeTooManyQueries      = "too_many_queries"
+	CodePriorityNotAllowed  = "priority_not_allowed"
+)
+
+// ErrorResponse is the body of every error. Code is a stable, machine-readable
+// identifier set for errors clients are expected to handle specifically.
 type ErrorResponse struct {
 	Error string    `json:"error"`
+	Code  string    `json:"code,omitempty"`
 	Time  time.Time `json:"timestamp"`
 }
 
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/access"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticAuthenticator maps credentials to fixed principals.
+type staticAuthenticator map[string]*auth.Principal
+
+func (s staticAuthenticator) Authenticate(_ context.Context, credential string) (*auth.Principal, error) {
+	if p, ok := s[credential]; ok {
+		return p, nil
+	}
+	return nil, auth.ErrInvalidCredential
+}
+
+var testPrincipals = staticAuthenticator{
+	"analyst": {Name: "ann", Roles: []string{"analyst"}, Scopes: []string{auth.ScopeQueryRead}},
+	"hr":      {Name: "hal", Roles: []string{"hr"}, Scopes: []string{auth.ScopeQueryRead}},
+	"finance": {Name: "fin", Roles: []string{"finance"}, Scopes: []string{auth.ScopeQueryRead}},
+}
+
+const testAccessPolicy = `{
+  "default": "allow",
+  "rules": [
+    {"table": "hr.*", "roles": ["hr"], "effect": "allow"},
+    {"table": "hr.*", "effect": "deny"},
+    {"table": "employees", "columns": ["salary"], "roles": ["hr", "finance"], "effect": "allow"},
+    {"table": "employees", "columns": ["salary"], "effect": "deny"}
+  ]
+}`
+
+func writePolicyFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func setupAccessDB(t *testing.T) *database.DB {
+	db, _ := setupTestDB(t)
+	for _, sql := range []string{
+		"CREATE TABLE employees AS SELECT 1 AS id, 'ann' AS name, 50000 AS salary, 'eng' AS dept UNION ALL SELECT 2, 'bob', 60000, 'ops'",
+		"CREATE SCHEMA hr",
+		"CREATE TABLE hr.reviews AS SELECT 1 AS employee_id, 'great' AS review",
+		"CREATE VIEW employee_directory AS SELECT id, name FROM employees",
+		"CREATE VIEW employee_details AS SELECT * FROM employees",
+	} {
+		_, err := db.GetConnection().Exec(sql)
+		require.NoError(t, err, sql)
+	}
+	return db
+}
+
+func newTestEnforcer(t *testing.T, db *database.DB, content string) *access.Enforcer {
+	policy, err := access.LoadPolicy(writePolicyFile(t, content))
+	require.NoError(t, err)
+	return access.NewEnforcer(db, policy)
+}
+
+func setupAccessRouter(t *testing.T, db *database.DB, enforcer *access.Enforcer, authenticator auth.Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RecoveryMiddleware())
+
+	manager := jobs.NewManager(db, defaultJobConfig())
+	t.Cleanup(manager.Close)
+
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), enforcer, nil)
+	jobHandler := handlers.NewJobHandler(manager, queryHandler)
+
+	api := router.Group("/", middleware.AuthMiddleware(authenticator))
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.POST("/jobs", jobHandler.Submit)
+	api.GET("/jobs/:id", jobHandler.Status)
+	api.GET("/jobs/:id/result", jobHandler.Result)
+	api.DELETE("/jobs/:id", jobHandler.Delete)
+	return router
+}
+
+func TestAccessReferences(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+
+	enforcer := newTestEnforcer(t, db, testAccessPolicy)
+	refs, err := enforcer.References(context.Background(),
+		"SELECT d.name, r.review FROM employee_directory d JOIN hr.reviews r ON r.employee_id = d.id WHERE d.id IN (SELECT id FROM employees WHERE salary > 0)")
+	require.NoError(t, err)
+
+	require.Len(t, refs, 2)
+	assert.Equal(t, "memory.hr.reviews", refs[0].String())
+	assert.Equal(t, []string{"employee_id", "review"}, refs[0].Columns)
+	assert.Equal(t, "memory.main.employees", refs[1].String())
+	assert.Equal(t, []string{"id", "name", "salary"}, refs[1].Columns)
+
+	_, err = enforcer.References(context.Background(), "SELECT * FROM missing_table")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, access.ErrUnverifiable)
+}
+
+func TestAccessPolicies(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+
+	router := setupAccessRouter(t, db, newTestEnforcer(t, db, testAccessPolicy), testPrincipals)
+
+	// DuckDB cannot serialize plans reading CSV files, so principals any
+	// deny rule applies to cannot run them.
+	csv := filepath.Join(t.TempDir(), "data.csv")
+	require.NoError(t, os.WriteFile(csv, []byte("a\n1\n"), 0o644))
+
+	tests := []struct {
+		sql      string
+		analyst  int
+		finance  int
+		hr       int
+		deniedOn string
+	}{
+		{"SELECT id, name FROM employees", 200, 200, 200, ""},
+		{"SELECT count(*) FROM employees", 200, 200, 200, ""},
+		{"SELECT * EXCLUDE (salary) FROM employees", 200, 200, 200, ""},
+		{"SELECT * FROM employee_directory", 200, 200, 200, ""},
+		{"SELECT salary FROM employees", 403, 200, 200, "access to column memory.main.employees.salary is denied"},
+		{"SELECT * FROM employees", 403, 200, 200, ""},
+		{"SELECT name FROM employees WHERE salary > 55000", 403, 200, 200, ""},
+		{"SELECT name FROM employees ORDER BY salary", 403, 200, 200, ""},
+		{"SELECT * FROM employee_details", 403, 200, 200, ""},
+		{"WITH s AS (SELECT salary FROM employees) SELECT avg(salary) FROM s", 403, 200, 200, ""},
+		{"SELECT 1 WHERE EXISTS (SELECT 1 FROM employees WHERE salary > 55000)", 403, 200, 200, ""},
+		{"SELECT * FROM hr.reviews", 403, 403, 200, "access to table memory.hr.reviews is denied"},
+		{"SELECT 1; SELECT review FROM hr.reviews", 403, 403, 200, ""},
+		{"SELECT * FROM read_csv('" + csv + "')", 403, 403, 403, "query cannot be checked against access policies"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			for key, expected := range map[string]int{"analyst": tt.analyst, "finance": tt.finance, "hr": tt.hr} {
+				w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: tt.sql}, "X-API-Key", key)
+				require.Equal(t, expected, w.Code, "%s: %s", key, w.Body.String())
+				if expected == http.StatusForbidden {
+					var response models.ErrorResponse
+					require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+					assert.Equal(t, models.CodeAccessDenied, response.Code)
+					if key == "analyst" {
+						assert.Contains(t, response.Error, tt.deniedOn)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("jobs are checked", func(t *testing.T) {
+		w := authRequest(router, "POST", "/jobs", models.QueryRequest{SQL: "SELECT salary FROM employees"}, "X-API-Key", "analyst")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, models.CodeAccessDenied, errorCode(t, w))
+	})
+
+	t.Run("invalid queries are bad requests", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT nope FROM employees"}, "X-API-Key", "analyst")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestAccessDefaultDeny(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+
+	router := setupAccessRouter(t, db, newTestEnforcer(t, db, `{
+		"default": "deny",
+		"rules": [
+			{"table": "main.employee*", "effect": "allow"},
+			{"table": "*", "principals": ["hal"], "effect": "allow"}
+		]
+	}`), testPrincipals)
+
+	w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT name FROM employees"}, "X-API-Key", "analyst")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT * FROM hr.reviews"}, "X-API-Key", "analyst")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT * FROM hr.reviews"}, "X-API-Key", "hr")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT 42"}, "X-API-Key", "analyst")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestLoadPolicyValidation(t *testing.T) {
+	for name, content := range map[string]string{
+		"bad default":  `{"default": "maybe"}`,
+		"bad effect":   `{"rules": [{"table": "t", "effect": "hide"}]}`,
+		"no table":     `{"rules": [{"effect": "deny"}]}`,
+		"long table":   `{"rules": [{"table": "a.b.c.d", "effect": "deny"}]}`,
+		"bad pattern":  `{"rules": [{"table": "[", "effect": "deny"}]}`,
+		"invalid json": `{"rules": `,
+		"no filter":    `{"row_filters": [{"table": "t"}]}`,
+		"filter table": `{"row_filters": [{"table": "a.b.c.d", "filter": "true"}]}`,
+		"placeholder":  `{"row_filters": [{"table": "t", "filter": "tenant = {{tenant}}"}]}`,
+		"empty claim":  `{"row_filters": [{"table": "t", "filter": "tenant = {{claims.}}"}]}`,
+		"mask columns": `{"rules": [{"table": "t", "effect": "mask", "mask": "hash"}]}`,
+		"mask kind":    `{"rules": [{"table": "t", "columns": ["c"], "effect": "mask", "mask": "scramble"}]}`,
+		"mask effect":  `{"rules": [{"table": "t", "columns": ["c"], "effect": "allow", "mask": "hash"}]}`,
+		"mask keep":    `{"rules": [{"table": "t", "columns": ["c"], "effect": "mask", "mask": "partial", "keep": -1}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := access.LoadPolicy(writePolicyFile(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAdminRouter(db *database.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.RecoveryMiddleware())
+
+	running := registry.New()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), running, testPolicy(db, "select"), nil, nil)
+	adminHandler := handlers.NewAdminHandler(running, nil)
+	router.POST("/query", queryHandler.ExecuteQuery)
+	router.GET("/admin/queries", adminHandler.ListQueries)
+	router.DELETE("/admin/queries/:request_id", adminHandler.CancelQuery)
+
+	return router
+}
+
+func queryWithRequestID(router *gin.Engine, requestID, sql string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.QueryRequest{SQL: sql})
+	req := httptest.NewRequest("POST", "/query", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", requestID)
+	req.RemoteAddr = "192.0.2.10:4567"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func listRunningQueries(t *testing.T, router *gin.Engine) []models.RunningQuery {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/queries", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Queries []models.RunningQuery `json:"queries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Queries
+}
+
+func TestCancelRunningQuery(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupAdminRouter(db)
+	assert.Empty(t, listRunningQueries(t, router))
+
+	result := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		result <- queryWithRequestID(router, "runaway-1", slowSQL)
+	}()
+
+	var running []models.RunningQuery
+	require.Eventually(t, func() bool {
+		running = listRunningQueries(t, router)
+		return len(running) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "runaway-1", running[0].RequestID)
+	assert.Equal(t, slowSQL, running[0].SQL)
+	assert.Equal(t, "192.0.2.10", running[0].ClientIP)
+	assert.False(t, running[0].StartedAt.IsZero())
+
+	t.Run("request IDs of running queries cannot be reused", func(t *testing.T) {
+		w := queryWithRequestID(router, "runaway-1", "SELECT 1")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/queries/runaway-1", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var cancelled models.RunningQuery
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cancelled))
+	assert.True(t, cancelled.Cancelled)
+
+	select {
+	case w := <-result:
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response models.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "Query cancelled", response.Error)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled query did not return")
+	}
+
+	assert.Empty(t, listRunningQueries(t, router))
+
+	// The request ID is free again once the query has finished.
+	assert.Equal(t, http.StatusOK, queryWithRequestID(router, "runaway-1", "SELECT 1").Code)
+}
+
+func TestCancelUnknownQuery(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupAdminRouter(db)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/queries/nope", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
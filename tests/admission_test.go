@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/admission"
+	"github.com/lab1702/goduck/internal/config"
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acquireAsync starts waiting for a slot and returns the channel receiving
+// the release function, or nil if the wait failed.
+func acquireAsync(controller *admission.Controller, ctx context.Context, priority admission.Priority) <-chan func() {
+	ch := make(chan func(), 1)
+	go func() {
+		release, err := controller.Acquire(ctx, priority)
+		if err != nil {
+			release = nil
+		}
+		ch <- release
+	}()
+	return ch
+}
+
+func waitForQueue(t *testing.T, controller *admission.Controller, interactive, batch int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		s := controller.Stats()
+		return s.Waiting[admission.Interactive] == interactive && s.Waiting[admission.Batch] == batch
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestAdmissionController(t *testing.T) {
+	controller := admission.NewController(admission.Config{
+		MaxConcurrent:   1,
+		QueueSize:       2,
+		QueueTimeout:    time.Minute,
+		BatchPrincipals: []string{"etl"},
+	})
+	ctx := context.Background()
+
+	release, err := controller.Acquire(ctx, admission.Interactive)
+	require.NoError(t, err)
+	assert.Equal(t, 1, controller.Stats().Running)
+
+	batch := acquireAsync(controller, ctx, admission.Batch)
+	waitForQueue(t, controller, 0, 1)
+	interactive := acquireAsync(controller, ctx, admission.Interactive)
+	waitForQueue(t, controller, 1, 1)
+
+	_, err = controller.Acquire(ctx, admission.Interactive)
+	assert.ErrorIs(t, err, admission.ErrQueueFull)
+
+	// Interactive queries are admitted first, although they queued later.
+	release()
+	release() // releasing twice frees one slot only
+	releaseInteractive := <-interactive
+	require.NotNil(t, releaseInteractive)
+	assert.Equal(t, 1, controller.Stats().Running)
+	select {
+	case <-batch:
+		t.Fatal("batch query admitted beyond the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseInteractive()
+	releaseBatch := <-batch
+	require.NotNil(t, releaseBatch)
+	releaseBatch()
+	assert.Equal(t, admission.Stats{MaxConcurrent: 1}, controller.Stats())
+
+	assert.Equal(t, admission.Batch, controller.Priority("etl", admission.Interactive))
+	assert.Equal(t, admission.Interactive, controller.Priority("ann", admission.Interactive))
+	assert.Equal(t, admission.Batch, controller.Priority("", admission.Batch))
+
+	t.Run("waits end", func(t *testing.T) {
+		controller := admission.NewController(admission.Config{MaxConcurrent: 1, QueueSize: 10, QueueTimeout: 20 * time.Millisecond})
+		release, err := controller.Acquire(ctx, admission.Interactive)
+		require.NoError(t, err)
+		defer release()
+
+		_, err = controller.Acquire(ctx, admission.Interactive)
+		assert.ErrorIs(t, err, admission.ErrQueueTimeout)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = controller.Acquire(cancelled, admission.Batch)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.Equal(t, admission.Stats{MaxConcurrent: 1, Running: 1}, controller.Stats(), "queue emptied")
+	})
+
+	_, err = admission.ParsePriority("urgent")
+	assert.Error(t, err)
+}
+
+func TestAdmissionControl(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	controller := admission.NewController(admission.Config{MaxConcurrent: 1, QueueSize: 1, QueueTimeout: 1500 * time.Millisecond})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, controller)
+	router.POST("/query", queryHandler.ExecuteQuery)
+	router.GET("/metrics", queryHandler.Metrics)
+
+	query := models.QueryRequest{SQL: "SELECT 1"}
+	w := authRequest(router, "POST", "/query", query, "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	const (
+		full     = `goduck_query_queue_rejected_total{priority="interactive",reason="full"}`
+		timedOut = `goduck_query_queue_rejected_total{priority="batch",reason="timeout"}`
+		waited   = `goduck_query_queue_wait_seconds_count{priority="interactive"}`
+	)
+	before := scrape(t, router)
+
+	release, err := controller.Acquire(context.Background(), admission.Interactive)
+	require.NoError(t, err)
+
+	t.Run("timeout", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", query, "X-Query-Priority", "batch")
+		require.Equal(t, http.StatusServiceUnavailable, w.Code, w.Body.String())
+		assert.Equal(t, models.CodeQueueTimeout, errorCode(t, w))
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		waiting := acquireAsync(controller, context.Background(), admission.Interactive)
+		waitForQueue(t, controller, 1, 0)
+
+		w := authRequest(router, "GET", "/metrics", nil, "", "")
+		var response handlers.MetricsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, &handlers.AdmissionStats{MaxConcurrent: 1, Running: 1, QueuedInteractive: 1}, response.Admission)
+
+		w = authRequest(router, "POST", "/query", query, "", "")
+		require.Equal(t, http.StatusServiceUnavailable, w.Code, w.Body.String())
+		assert.Equal(t, models.CodeQueueFull, errorCode(t, w))
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		release()
+		(<-waiting)()
+	})
+
+	w = authRequest(router, "POST", "/query", query, "X-Query-Priority", "urgent")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = authRequest(router, "POST", "/query", query, "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	after := scrape(t, router)
+	assert.Equal(t, 1.0, after[full]-before[full])
+	assert.Equal(t, 1.0, after[timedOut]-before[timedOut])
+	assert.Equal(t, 3.0, after[waited]-before[waited])
+	assert.Equal(t, 0.0, after["goduck_queries_running"])
+}
+
+func TestRoleQueryLimits(t *testing.T) {
+	limits, err := admission.ParseRoleLimits("analyst=concurrent:1; hr=priority:batch ;etl=concurrent:4,priority:batch")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]admission.RoleLimit{
+		"analyst": {MaxConcurrent: 1},
+		"hr":      {MaxPriority: admission.Batch},
+		"etl":     {MaxConcurrent: 4, MaxPriority: admission.Batch},
+	}, limits)
+	for _, s := range []string{"analyst", "analyst=concurrent:0", "analyst=concurrent:x", "analyst=priority:urgent", "analyst=memory:1GB"} {
+		_, err := admission.ParseRoleLimits(s)
+		assert.Error(t, err, s)
+	}
+
+	controller := admission.NewController(admission.Config{MaxConcurrent: 10, QueueSize: 10, QueueTimeout: time.Second, RoleLimits: limits})
+
+	t.Run("the most permissive role applies", func(t *testing.T) {
+		for _, tc := range []struct {
+			roles   []string
+			limit   admission.RoleLimit
+			limited bool
+		}{
+			{nil, admission.RoleLimit{}, false},
+			{[]string{"finance"}, admission.RoleLimit{}, false},
+			{[]string{"finance", "etl"}, admission.RoleLimit{MaxConcurrent: 4, MaxPriority: admission.Batch}, true},
+			{[]string{"analyst", "etl"}, admission.RoleLimit{MaxConcurrent: 4}, true},
+			{[]string{"analyst", "hr"}, admission.RoleLimit{}, true},
+		} {
+			limit, limited := controller.RoleLimit(tc.roles)
+			assert.Equal(t, tc.limit, limit, tc.roles)
+			assert.Equal(t, tc.limited, limited, tc.roles)
+		}
+	})
+
+	gin.SetMode(gin.TestMode)
+	db, _ := setupTestDB(t)
+	defer db.Close()
+	router := gin.New()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, controller)
+	router.POST("/query", middleware.AuthMiddleware(testPrincipals), queryHandler.ExecuteQuery)
+
+	query := func(key, priority string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/query", strings.NewReader(`{"sql": "SELECT 1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		if priority != "" {
+			req.Header.Set("X-Query-Priority", priority)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("concurrency ceiling", func(t *testing.T) {
+		end, err := controller.Reserve("ann", 1)
+		require.NoError(t, err)
+		_, err = controller.Reserve("ann", 1)
+		var tooMany *admission.TooManyQueriesError
+		require.ErrorAs(t, err, &tooMany)
+
+		w := query("analyst", "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+		assert.Equal(t, models.CodeTooManyQueries, errorCode(t, w))
+		assert.Contains(t, w.Body.String(), "your roles allow 1 at once")
+		assert.Equal(t, http.StatusOK, query("hr", "").Code, "other principals are not affected")
+
+		end()
+		end() // ending twice frees one reservation only
+		assert.Equal(t, http.StatusOK, query("analyst", "").Code)
+		assert.Equal(t, http.StatusOK, query("analyst", "").Code, "the reservation ends with the query")
+	})
+
+	t.Run("priority ceiling", func(t *testing.T) {
+		w := query("hr", "interactive")
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.Equal(t, models.CodePriorityNotAllowed, errorCode(t, w))
+		assert.Equal(t, http.StatusOK, query("hr", "batch").Code)
+		assert.Equal(t, http.StatusOK, query("hr", "").Code, "run as batch")
+		assert.Equal(t, http.StatusOK, query("finance", "interactive").Code, "roles without limits")
+	})
+}
+
+func TestRoleQueryLimitsConfig(t *testing.T) {
+	t.Setenv("GODUCK_ROLE_QUERY_LIMITS", "analyst=concurrent:2")
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]admission.RoleLimit{"analyst": {MaxConcurrent: 2}}, cfg.RoleQueryLimits)
+
+	t.Setenv("GODUCK_MAX_CONCURRENT_QUERIES", "0")
+	_, err = config.Load()
+	assert.Error(t, err, "ceilings are enforced by admission control")
+
+	t.Setenv("GODUCK_ROLE_QUERY_LIMITS", "analyst=threads:2")
+	_, err = config.Load()
+	assert.Error(t, err)
+}
+
+func TestJobAdmission(t *testing.T) {
+	limits, err := admission.ParseRoleLimits("analyst=concurrent:1")
+	require.NoError(t, err)
+	controller := admission.NewController(admission.Config{MaxConcurrent: 1, QueueSize: 10, QueueTimeout: 5 * time.Second, RoleLimits: limits})
+
+	db, _ := setupTestDB(t)
+	defer db.Close()
+	cfg := defaultJobConfig()
+	cfg.Admission = controller
+	manager := jobs.NewManager(db, cfg)
+	defer manager.Close()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, controller)
+	jobHandler := handlers.NewJobHandler(manager, queryHandler)
+	api := router.Group("/", middleware.AuthMiddleware(testPrincipals))
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.POST("/jobs", jobHandler.Submit)
+
+	submit := func(key string) *jobs.Job {
+		w := authRequest(router, "POST", "/jobs", models.QueryRequest{SQL: "SELECT 1"}, "X-API-Key", key)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		var status models.JobStatus
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		job, ok := manager.Get(status.ID)
+		require.True(t, ok)
+		return job
+	}
+
+	t.Run("jobs wait for a slot as batch queries", func(t *testing.T) {
+		release, err := controller.Acquire(context.Background(), admission.Interactive)
+		require.NoError(t, err)
+
+		job := submit("hr")
+		waitForQueue(t, controller, 0, 1)
+		assert.Equal(t, jobs.StateQueued, job.State())
+
+		release()
+		<-job.Done()
+		assert.Equal(t, jobs.StateSucceeded, job.State())
+		assert.Equal(t, 0, controller.Stats().Running)
+	})
+
+	t.Run("jobs count against role ceilings", func(t *testing.T) {
+		release, err := controller.Acquire(context.Background(), admission.Interactive)
+		require.NoError(t, err)
+
+		job := submit("analyst")
+		waitForQueue(t, controller, 0, 1)
+		for _, path := range []string{"/query", "/jobs"} {
+			w := authRequest(router, "POST", path, models.QueryRequest{SQL: "SELECT 1"}, "X-API-Key", "analyst")
+			assert.Equal(t, http.StatusTooManyRequests, w.Code, path)
+			assert.Equal(t, models.CodeTooManyQueries, errorCode(t, w), path)
+		}
+
+		release()
+		<-job.Done()
+		assert.Equal(t, jobs.StateSucceeded, job.State())
+		w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT 1"}, "X-API-Key", "analyst")
+		assert.Equal(t, http.StatusOK, w.Code, "the reservation ends with the job's query")
+	})
+
+	t.Run("cancelled jobs end their reservation", func(t *testing.T) {
+		release, err := controller.Acquire(context.Background(), admission.Interactive)
+		require.NoError(t, err)
+		defer release()
+
+		job := submit("analyst")
+		waitForQueue(t, controller, 0, 1)
+		_, ok := manager.Delete(job.ID)
+		require.True(t, ok)
+		<-job.Done()
+		waitForQueue(t, controller, 0, 0)
+
+		end, err := controller.Reserve("ann", 1)
+		require.NoError(t, err)
+		end()
+	})
+}
+
+func TestJobAdmissionTimeout(t *testing.T) {
+	controller := admission.NewController(admission.Config{MaxConcurrent: 1, QueueSize: 10, QueueTimeout: 20 * time.Millisecond})
+
+	db, _ := setupTestDB(t)
+	defer db.Close()
+	cfg := defaultJobConfig()
+	cfg.Admission = controller
+	manager := jobs.NewManager(db, cfg)
+	defer manager.Close()
+
+	release, err := controller.Acquire(context.Background(), admission.Interactive)
+	require.NoError(t, err)
+	defer release()
+
+	job, err := manager.Submit(context.Background(), "", models.QueryRequest{SQL: "SELECT 1"}, "SELECT 1", nil, nil)
+	require.NoError(t, err)
+	<-job.Done()
+	status := job.Status()
+	assert.Equal(t, jobs.StateFailed, status.State)
+	assert.Equal(t, admission.ErrQueueTimeout.Error(), status.Error)
+	assert.Nil(t, status.StartedAt)
+	assert.Empty(t, status.Elapsed)
+}
@@ -0,0 +1,28 @@
+//go:build !duckdb_arrow
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryArrowUnavailable(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(`{"sql": "SELECT 1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.apache.arrow.stream")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
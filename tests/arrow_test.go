@@ -0,0 +1,147 @@
+//go:build duckdb_arrow
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryArrow(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	body := `{"sql": "SELECT i::INTEGER AS id, i * 1.5 AS score, 'row ' || i AS label FROM range(5000) t(i) WHERE i >= ?", "params": [1000]}`
+	req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.apache.arrow.stream")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/vnd.apache.arrow.stream", w.Header().Get("Content-Type"))
+
+	reader, err := ipc.NewReader(w.Body)
+	require.NoError(t, err)
+	defer reader.Release()
+
+	schema := reader.Schema()
+	require.Equal(t, 3, schema.NumFields())
+	assert.Equal(t, "id", schema.Field(0).Name)
+	assert.Equal(t, arrow.PrimitiveTypes.Int32, schema.Field(0).Type)
+	assert.Equal(t, arrow.DECIMAL128, schema.Field(1).Type.ID())
+	assert.Equal(t, arrow.STRING, schema.Field(2).Type.ID())
+
+	rows := 0
+	for reader.Next() {
+		rec := reader.Record()
+		if rows == 0 {
+			assert.Equal(t, int32(1000), rec.Column(0).(*array.Int32).Value(0))
+			assert.Equal(t, "row 1000", rec.Column(2).(*array.String).Value(0))
+		}
+		rows += int(rec.NumRows())
+	}
+	require.NoError(t, reader.Err())
+	assert.Equal(t, 4000, rows)
+}
+
+func TestQueryArrowNamedParams(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(`{"sql": "SELECT $id", "params": {"id": 1}, "format": "arrow"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestQueryParquet(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	for _, codec := range []string{"", "zstd", "gzip", "uncompressed"} {
+		t.Run("compression "+codec, func(t *testing.T) {
+			body := `{"sql": "SELECT i AS id, 'row ' || i AS label FROM range(300000) t(i)", "filename": "rows", "parquet": {"compression": "` + codec + `"}}`
+			req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/vnd.apache.parquet")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, "application/vnd.apache.parquet", w.Header().Get("Content-Type"))
+			assert.Equal(t, "attachment; filename=rows.parquet", w.Header().Get("Content-Disposition"))
+
+			pf, err := file.NewParquetReader(bytes.NewReader(w.Body.Bytes()))
+			require.NoError(t, err)
+			defer pf.Close()
+
+			assert.EqualValues(t, 300000, pf.NumRows())
+			assert.Equal(t, 3, pf.NumRowGroups())
+
+			fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+			require.NoError(t, err)
+			tbl, err := fr.ReadTable(context.Background())
+			require.NoError(t, err)
+			defer tbl.Release()
+			assert.Equal(t, "label", tbl.Schema().Field(1).Name)
+		})
+	}
+}
+
+func TestJobResultArrow(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, defaultJobConfig())
+
+	// The result is read back without parameters, so Arrow works even for
+	// jobs submitted with named parameters.
+	submitted := submitJob(t, router, models.QueryRequest{
+		SQL:    "SELECT range AS n FROM range($n)",
+		Params: json.RawMessage(`{"n": 2500}`),
+	})
+	status := waitForJob(t, router, submitted.ID, jobs.StateSucceeded, jobs.StateFailed)
+	require.Equal(t, jobs.StateSucceeded, status.State, status.Error)
+
+	w := getJob(t, router, "GET", "/jobs/"+submitted.ID+"/result?format=arrow")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	reader, err := ipc.NewReader(w.Body)
+	require.NoError(t, err)
+	defer reader.Release()
+
+	rows := 0
+	for reader.Next() {
+		rows += int(reader.Record().NumRows())
+	}
+	require.NoError(t, reader.Err())
+	assert.Equal(t, 2500, rows)
+}
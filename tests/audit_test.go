@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/audit"
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openAuditLog(t *testing.T, path string, maxSize int64, maxFiles int) *audit.Log {
+	log, err := audit.Open(path, maxSize, maxFiles)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := openAuditLog(t, path, 2048, 2)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		principal := "ann"
+		if i%2 == 1 {
+			principal = "bob"
+		}
+		require.NoError(t, log.Write(audit.Record{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Principal: principal,
+			SQL:       "SELECT 1",
+			Rows:      int64(i),
+			Outcome:   audit.OutcomeSuccess,
+		}))
+	}
+	require.NoError(t, log.Verify())
+
+	t.Run("rotation", func(t *testing.T) {
+		for _, name := range []string{path, path + ".1", path + ".2"} {
+			info, err := os.Stat(name)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, info.Size(), int64(2048))
+		}
+		_, err := os.Stat(path + ".3")
+		assert.True(t, os.IsNotExist(err), "only two rotated files are kept")
+
+		records, err := log.Read(audit.Filter{})
+		require.NoError(t, err)
+		require.NotEmpty(t, records)
+		assert.Less(t, len(records), 30, "the oldest records were rotated out")
+		assert.Equal(t, int64(29), records[0].Rows, "newest first")
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		records, err := log.Read(audit.Filter{
+			From:      start.Add(20 * time.Minute),
+			To:        start.Add(26 * time.Minute),
+			Principal: "bob",
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, int64(25), records[0].Rows)
+		assert.Equal(t, int64(21), records[2].Rows)
+
+		records, err = log.Read(audit.Filter{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, int64(29), records[0].Rows)
+		assert.Equal(t, int64(28), records[1].Rows)
+	})
+
+	t.Run("chain continues after reopening", func(t *testing.T) {
+		require.NoError(t, log.Close())
+		log = openAuditLog(t, path, 2048, 2)
+		require.NoError(t, log.Write(audit.Record{SQL: "SELECT 2", Outcome: audit.OutcomeSuccess}))
+		require.NoError(t, log.Verify())
+
+		records, err := log.Read(audit.Filter{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, records[1].Hash, records[0].PrevHash)
+	})
+
+	t.Run("tampering is detected", func(t *testing.T) {
+		rotated := path + ".1"
+		data, err := os.ReadFile(rotated)
+		require.NoError(t, err)
+		lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+		require.GreaterOrEqual(t, len(lines), 3)
+
+		edited := append([]string{}, lines...)
+		edited[1] = strings.Replace(edited[1], `"principal":"`, `"principal":"x`, 1)
+		require.NoError(t, os.WriteFile(rotated, []byte(strings.Join(edited, "")+"\n"), 0o600))
+		assert.ErrorContains(t, log.Verify(), "does not match its hash")
+
+		removed := append(append([]string{}, lines[:1]...), lines[2:]...)
+		require.NoError(t, os.WriteFile(rotated, []byte(strings.Join(removed, "")+"\n"), 0o600))
+		assert.ErrorContains(t, log.Verify(), "does not follow")
+	})
+}
+
+func TestAuditNormalize(t *testing.T) {
+	for sql, expected := range map[string]string{
+		"SELECT  1":                                 "SELECT 1",
+		"\n\tSELECT *\n  FROM t  -- comment\n":      "SELECT * FROM t",
+		"SELECT /* a\nb */ 'x  -- y' FROM \"a  b\"": "SELECT 'x  -- y' FROM \"a  b\"",
+		"SELECT 'it''s   ok'":                       "SELECT 'it''s   ok'",
+	} {
+		assert.Equal(t, expected, audit.Normalize(sql), sql)
+	}
+}
+
+func TestAuditEndpoint(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+
+	log := openAuditLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 1<<20, 1)
+	principals := staticAuthenticator{
+		"analyst": testPrincipals["analyst"],
+		"admin":   {Name: "root", Scopes: []string{auth.ScopeAdmin, auth.ScopeQueryRead}},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.RecoveryMiddleware())
+
+	manager := jobs.NewManager(db, defaultJobConfig())
+	t.Cleanup(manager.Close)
+	running := registry.New()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), running, testPolicy(db, "select"), newTestEnforcer(t, db, testAccessPolicy), nil)
+	jobHandler := handlers.NewJobHandler(manager, queryHandler)
+	adminHandler := handlers.NewAdminHandler(running, log)
+
+	api := router.Group("/", middleware.AuthMiddleware(principals), middleware.AuditMiddleware(log, nil))
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.POST("/jobs", jobHandler.Submit)
+	api.GET("/admin/audit", middleware.RequireScope(auth.ScopeAdmin), adminHandler.ListAudit)
+
+	for sql, status := range map[string]int{
+		"SELECT name\n  FROM employees  ORDER BY id": http.StatusOK,
+		"SELECT salary FROM employees":               http.StatusForbidden,
+		"CREATE TABLE t (a INT)":                     http.StatusForbidden,
+		"SELECT nope FROM employees":                 http.StatusBadRequest,
+	} {
+		w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: sql}, "X-API-Key", "analyst")
+		require.Equal(t, status, w.Code, w.Body.String())
+	}
+	w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT 42"}, "X-API-Key", "admin")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = authRequest(router, "POST", "/jobs", models.QueryRequest{SQL: "SELECT id FROM employees"}, "X-API-Key", "analyst")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var job models.JobStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+
+	type auditResponse struct {
+		Records     []audit.Record `json:"records"`
+		Count       int            `json:"count"`
+		Verified    *bool          `json:"verified"`
+		VerifyError string         `json:"verify_error"`
+	}
+	list := func(t *testing.T, query string) auditResponse {
+		w := authRequest(router, "GET", "/admin/audit"+query, nil, "X-API-Key", "admin")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response auditResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	// The job's record is written when the job is done.
+	require.Eventually(t, func() bool {
+		return list(t, "?principal=ann").Count == 5
+	}, 10*time.Second, 20*time.Millisecond)
+
+	bySQL := make(map[string]audit.Record)
+	for _, r := range list(t, "?principal=ann").Records {
+		bySQL[r.SQL] = r
+	}
+
+	r := bySQL["SELECT name FROM employees ORDER BY id"]
+	assert.Equal(t, audit.OutcomeSuccess, r.Outcome)
+	assert.Equal(t, "select", r.Class)
+	assert.Equal(t, []string{"memory.main.employees"}, r.Tables)
+	assert.Equal(t, int64(2), r.Rows)
+	assert.NotEmpty(t, r.RequestID)
+	assert.NotEmpty(t, r.ClientIP)
+
+	r = bySQL["SELECT salary FROM employees"]
+	assert.Equal(t, audit.OutcomeDenied, r.Outcome)
+	assert.Contains(t, r.Error, "access to column memory.main.employees.salary is denied")
+	assert.Equal(t, []string{"memory.main.employees"}, r.Tables)
+
+	r = bySQL["CREATE TABLE t (a INT)"]
+	assert.Equal(t, audit.OutcomeDenied, r.Outcome)
+	assert.Equal(t, "ddl", r.Class)
+	assert.Empty(t, r.Tables)
+
+	assert.Equal(t, audit.OutcomeFailed, bySQL["SELECT nope FROM employees"].Outcome)
+
+	r = bySQL["SELECT id FROM employees"]
+	assert.Equal(t, audit.OutcomeSuccess, r.Outcome)
+	assert.Equal(t, job.ID, r.JobID)
+	assert.Equal(t, int64(2), r.Rows)
+
+	t.Run("filters", func(t *testing.T) {
+		response := list(t, "?principal=root&verify=true")
+		require.Equal(t, 1, response.Count)
+		assert.Equal(t, "SELECT 42", response.Records[0].SQL)
+		require.NotNil(t, response.Verified)
+		assert.True(t, *response.Verified, response.VerifyError)
+
+		assert.Equal(t, 2, list(t, "?limit=2").Count)
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		assert.Equal(t, 0, list(t, "?from="+future).Count)
+		assert.Equal(t, 6, list(t, "?to="+future).Count)
+
+		for _, query := range []string{"?from=yesterday", "?limit=0", "?verify=maybe"} {
+			w := authRequest(router, "GET", "/admin/audit"+query, nil, "X-API-Key", "admin")
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+
+		w := authRequest(router, "GET", "/admin/audit", nil, "X-API-Key", "analyst")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyFile writes an API key file for the given key -> scopes map and
+// returns its path. Keys double as names.
+func writeKeyFile(t *testing.T, keys map[string][]string) string {
+	type entry struct {
+		Name   string   `json:"name"`
+		Hash   string   `json:"hash"`
+		Scopes []string `json:"scopes"`
+	}
+	var file struct {
+		Keys []entry `json:"keys"`
+	}
+	for key, scopes := range keys {
+		file.Keys = append(file.Keys, entry{Name: key, Hash: auth.HashKey(key), Scopes: scopes})
+	}
+
+	data, err := json.Marshal(file)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func setupAuthRouter(t *testing.T, db *database.DB, authenticator auth.Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.RecoveryMiddleware())
+
+	running := registry.New()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), running, testPolicy(db, "all"), nil, nil)
+	adminHandler := handlers.NewAdminHandler(running, nil)
+
+	router.GET("/health", queryHandler.Health)
+	api := router.Group("/", middleware.AuthMiddleware(authenticator))
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.GET("/admin/queries", middleware.RequireScope(auth.ScopeAdmin), adminHandler.ListQueries)
+
+	return router
+}
+
+func authRequest(router *gin.Engine, method, path string, body interface{}, header, value string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var response models.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response), w.Body.String())
+	return response.Code
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	keys, err := auth.LoadKeys(writeKeyFile(t, map[string][]string{
+		"reader-key": {auth.ScopeQueryRead},
+		"writer-key": {auth.ScopeQueryRead, auth.ScopeQueryWrite},
+		"admin-key":  {auth.ScopeAdmin},
+	}))
+	require.NoError(t, err)
+
+	router := setupAuthRouter(t, db, keys)
+	selectQuery := models.QueryRequest{SQL: "SELECT * FROM test_table"}
+	createQuery := models.QueryRequest{SQL: "CREATE TABLE created AS SELECT 1 AS id"}
+
+	t.Run("missing credentials", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", selectQuery, "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, models.CodeUnauthenticated, errorCode(t, w))
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", selectQuery, "X-API-Key", "guessed-key")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, models.CodeInvalidCredentials, errorCode(t, w))
+	})
+
+	t.Run("bearer and header keys", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", selectQuery, "Authorization", "Bearer reader-key")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = authRequest(router, "POST", "/query", selectQuery, "X-API-Key", "reader-key")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = authRequest(router, "POST", "/query", selectQuery, "Authorization", "Basic cmVhZGVyLWtleQ==")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("writes need query:write", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", createQuery, "X-API-Key", "reader-key")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, models.CodeInsufficientScope, errorCode(t, w))
+
+		w = authRequest(router, "POST", "/query", models.QueryRequest{SQL: "EXPLAIN ANALYZE DELETE FROM test_table"}, "X-API-Key", "reader-key")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = authRequest(router, "POST", "/query", createQuery, "X-API-Key", "writer-key")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("reads need query:read", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", selectQuery, "X-API-Key", "admin-key")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, models.CodeInsufficientScope, errorCode(t, w))
+	})
+
+	t.Run("admin routes need admin", func(t *testing.T) {
+		w := authRequest(router, "GET", "/admin/queries", nil, "X-API-Key", "writer-key")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = authRequest(router, "GET", "/admin/queries", nil, "X-API-Key", "admin-key")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("health needs no credentials", func(t *testing.T) {
+		w := authRequest(router, "GET", "/health", nil, "", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestLoadKeysValidation(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	hash := auth.HashKey("secret")
+
+	tests := map[string]string{
+		"missing name":  `{"keys":[{"hash":"` + hash + `","scopes":["query:read"]}]}`,
+		"plain key":     `{"keys":[{"name":"a","hash":"secret","scopes":["query:read"]}]}`,
+		"short hash":    `{"keys":[{"name":"a","hash":"sha256:abcd","scopes":["query:read"]}]}`,
+		"unknown scope": `{"keys":[{"name":"a","hash":"` + hash + `","scopes":["query:delete"]}]}`,
+		"duplicate":     `{"keys":[{"name":"a","hash":"` + hash + `"},{"name":"a","hash":"` + auth.HashKey("other") + `"}]}`,
+		"same hash":     `{"keys":[{"name":"a","hash":"` + hash + `"},{"name":"b","hash":"` + hash + `"}]}`,
+		"invalid json":  `{"keys":`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.LoadKeys(write(content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := auth.LoadKeys(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lab1702/goduck/internal/clientip"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustPrefixes(t *testing.T, entries ...string) clientip.Prefixes {
+	t.Helper()
+	prefixes, err := clientip.ParsePrefixes(entries)
+	require.NoError(t, err)
+	return prefixes
+}
+
+func TestClientIPResolver(t *testing.T) {
+	trusted := mustPrefixes(t, "10.0.0.0/8", "2001:db8:ffff::1")
+
+	for _, tc := range []struct {
+		name       string
+		header     string
+		remoteAddr string
+		values     []string
+		expected   string
+	}{
+		{"untrusted peer", clientip.HeaderXForwardedFor, "198.51.100.7:4000", []string{"203.0.113.1"}, "198.51.100.7"},
+		{"trusted peer without header", clientip.HeaderXForwardedFor, "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"trusted peer", clientip.HeaderXForwardedFor, "10.0.0.1:4000", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"forged entries are skipped", clientip.HeaderXForwardedFor, "10.0.0.1:4000", []string{"1.2.3.4, 203.0.113.1, 10.0.0.2"}, "203.0.113.1"},
+		{"header lines are joined", clientip.HeaderXForwardedFor, "10.0.0.1:4000", []string{"1.2.3.4", "203.0.113.1"}, "203.0.113.1"},
+		{"all hops trusted", clientip.HeaderXForwardedFor, "10.0.0.1:4000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"invalid hop", clientip.HeaderXForwardedFor, "10.0.0.1:4000", []string{"203.0.113.1, unknown, 10.0.0.2"}, "10.0.0.2"},
+		{"IPv6 peer", clientip.HeaderXForwardedFor, "[2001:db8:ffff::1]:4000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"X-Real-IP", clientip.HeaderXRealIP, "10.0.0.1:4000", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"X-Real-IP is not X-Forwarded-For", clientip.HeaderXRealIP, "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"Forwarded", clientip.HeaderForwarded, "10.0.0.1:4000", []string{`for=192.0.2.60;proto=http;by=10.0.0.1`}, "192.0.2.60"},
+		{"Forwarded IPv6 with port", clientip.HeaderForwarded, "10.0.0.1:4000", []string{`For="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"Forwarded chain", clientip.HeaderForwarded, "10.0.0.1:4000", []string{`for=1.2.3.4, for=203.0.113.1;proto="h,ttp", for=10.0.0.2`}, "203.0.113.1"},
+		{"Forwarded obfuscated", clientip.HeaderForwarded, "10.0.0.1:4000", []string{`for=_hidden, for=10.0.0.2`}, "10.0.0.2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := make(http.Header)
+			h.Set("X-Forwarded-For", "198.51.100.99")
+			h.Del(tc.header)
+			for _, value := range tc.values {
+				h.Add(tc.header, value)
+			}
+			addr, ok := clientip.NewResolver(trusted, tc.header).Resolve(tc.remoteAddr, h)
+			require.True(t, ok)
+			assert.Equal(t, tc.expected, addr.String())
+		})
+	}
+
+	_, err := clientip.ParsePrefixes([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = clientip.ParsePrefixes([]string{"example.com"})
+	assert.Error(t, err)
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	require.NoError(t, router.SetTrustedProxies(nil))
+	router.Use(middleware.ClientIPMiddleware(clientip.NewResolver(mustPrefixes(t, "10.0.0.0/8"), clientip.HeaderForwarded)))
+	router.Use(middleware.IPFilterMiddleware(mustPrefixes(t, "203.0.113.0/24", "10.0.0.0/8"), mustPrefixes(t, "203.0.113.66")))
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	get := func(remoteAddr, forwarded string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			req.Header.Set("Forwarded", forwarded)
+		}
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("10.0.0.1:4000", "for=203.0.113.5")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "203.0.113.5", w.Body.String())
+
+	w = get("10.0.0.1:4000", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10.0.0.1", w.Body.String(), "the proxy's own requests, e.g. health checks")
+
+	w = get("10.0.0.1:4000", "for=198.51.100.1")
+	assert.Equal(t, http.StatusForbidden, w.Code, "outside the allow list")
+	assert.Equal(t, models.CodeAddressNotAllowed, errorCode(t, w))
+
+	w = get("10.0.0.1:4000", "for=203.0.113.66")
+	assert.Equal(t, http.StatusForbidden, w.Code, "deny takes precedence")
+
+	w = get("198.51.100.1:4000", "for=203.0.113.5")
+	assert.Equal(t, http.StatusForbidden, w.Code, "untrusted peers cannot claim an allowed address")
+}
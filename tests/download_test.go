@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryDownloadFormats(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	sql, _ := json.Marshal(`SELECT * FROM (VALUES (1, 'plain', 1.5), (2, 'a,b "c"', NULL), (3, '', 2.0), (4, 'tab' || chr(9) || 'here', -0.25)) t(id, label, score) ORDER BY id`)
+
+	tests := []struct {
+		name        string
+		accept      string
+		body        string
+		contentType string
+		disposition string
+		expected    string
+	}{
+		{
+			name:        "csv via accept",
+			accept:      "text/csv",
+			body:        `{"sql": ` + string(sql) + `}`,
+			contentType: "text/csv",
+			disposition: `attachment; filename=result.csv`,
+			expected:    "id,label,score\r\n1,plain,1.50\r\n2,\"a,b \"\"c\"\"\",\r\n3,\"\",2.00\r\n4,tab\there,-0.25\r\n",
+		},
+		{
+			name:        "csv options",
+			body:        `{"sql": ` + string(sql) + `, "format": "csv", "filename": "../reports/q3 sales.xlsx", "csv": {"delimiter": ";", "quote": "'", "quote_all": true, "header": false, "null": "NULL"}}`,
+			contentType: "text/csv",
+			disposition: `attachment; filename=q3_sales.csv`,
+			expected:    "'1';'plain';'1.50'\r\n'2';'a,b \"c\"';NULL\r\n'3';'';'2.00'\r\n'4';'tab\there';'-0.25'\r\n",
+		},
+		{
+			name:        "tsv",
+			accept:      "text/tab-separated-values",
+			body:        `{"sql": ` + string(sql) + `, "filename": "scores"}`,
+			contentType: "text/tab-separated-values",
+			disposition: `attachment; filename=scores.tsv`,
+			expected:    "id\tlabel\tscore\n1\tplain\t1.50\n2\ta,b \"c\"\t\n3\t\t2.00\n4\ttab\\there\t-0.25\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tt.disposition, w.Header().Get("Content-Disposition"))
+			assert.Equal(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func TestQueryDownloadOptionErrors(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "multi-character delimiter", body: `{"sql": "SELECT 1", "format": "csv", "csv": {"delimiter": "||"}}`},
+		{name: "delimiter equals quote", body: `{"sql": "SELECT 1", "format": "csv", "csv": {"delimiter": "'", "quote": "'"}}`},
+		{name: "unknown parquet codec", body: `{"sql": "SELECT 1", "format": "parquet", "parquet": {"compression": "lzo"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/marcboeker/go-duckdb/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeValue(t *testing.T) {
+	huge, _ := new(big.Int).SetString("170141183460469231731687303715884105727", 10)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 678901000, time.UTC)
+
+	tests := []struct {
+		name     string
+		typeName string
+		value    interface{}
+		expected interface{}
+	}{
+		{name: "null", typeName: "INTEGER", value: nil, expected: nil},
+		{name: "boolean", typeName: "BOOLEAN", value: true, expected: true},
+		{name: "integer", typeName: "INTEGER", value: int32(3), expected: int32(3)},
+		{name: "ubigint", typeName: "UBIGINT", value: uint64(math.MaxUint64), expected: uint64(math.MaxUint64)},
+		{name: "hugeint", typeName: "HUGEINT", value: huge, expected: "170141183460469231731687303715884105727"},
+		{name: "double", typeName: "DOUBLE", value: 1.25, expected: 1.25},
+		{name: "nan", typeName: "DOUBLE", value: math.NaN(), expected: "NaN"},
+		{name: "infinity", typeName: "FLOAT", value: float32(math.Inf(1)), expected: "Infinity"},
+		{name: "negative infinity", typeName: "DOUBLE", value: math.Inf(-1), expected: "-Infinity"},
+		{name: "decimal keeps scale", typeName: "DECIMAL(10,2)", value: duckdb.Decimal{Width: 10, Scale: 2, Value: big.NewInt(1250)}, expected: "12.50"},
+		{name: "small negative decimal", typeName: "DECIMAL(5,3)", value: duckdb.Decimal{Width: 5, Scale: 3, Value: big.NewInt(-5)}, expected: "-0.005"},
+		{name: "wide decimal", typeName: "DECIMAL(38,1)", value: duckdb.Decimal{Width: 38, Scale: 1, Value: huge}, expected: "17014118346046923173168730371588410572.7"},
+		{name: "varchar", typeName: "VARCHAR", value: "hi", expected: "hi"},
+		{name: "blob", typeName: "BLOB", value: []byte{'a', 0, 'b'}, expected: "YQBi"},
+		{name: "uuid", typeName: "UUID", value: []byte{0x46, 0xbf, 0x9e, 0x52, 0x2f, 0x62, 0x49, 0x3b, 0x89, 0x61, 0xdf, 0x81, 0x02, 0x02, 0x3d, 0x8c}, expected: "46bf9e52-2f62-493b-8961-df8102023d8c"},
+		{name: "date", typeName: "DATE", value: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), expected: "2024-02-29"},
+		{name: "time", typeName: "TIME", value: time.Date(1, 1, 1, 13, 14, 15, 123456000, time.UTC), expected: "13:14:15.123456"},
+		{name: "timetz", typeName: "TIME WITH TIME ZONE", value: time.Date(1, 1, 1, 11, 14, 15, 0, time.UTC), expected: "11:14:15+00:00"},
+		{name: "timestamp", typeName: "TIMESTAMP", value: ts, expected: "2024-01-02T03:04:05.678901Z"},
+		{name: "timestamptz", typeName: "TIMESTAMPTZ", value: ts.In(time.FixedZone("x", 5*3600)), expected: "2024-01-02T03:04:05.678901Z"},
+		{name: "interval", typeName: "INTERVAL", value: duckdb.Interval{Months: 14, Days: 3, Micros: 14706789000}, expected: "P1Y2M3DT4H5M6.789S"},
+		{name: "negative interval", typeName: "INTERVAL", value: duckdb.Interval{Micros: -1500000}, expected: "PT-1.5S"},
+		{name: "zero interval", typeName: "INTERVAL", value: duckdb.Interval{}, expected: "PT0S"},
+		{name: "list of dates", typeName: "DATE[]", value: []interface{}{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil}, expected: []interface{}{"2024-01-01", nil}},
+		{name: "array of doubles", typeName: "DOUBLE[2]", value: []interface{}{math.NaN(), 1.0}, expected: []interface{}{"NaN", 1.0}},
+		{
+			name:     "struct",
+			typeName: `STRUCT("a b" DATE, "c" BLOB[])`,
+			value:    map[string]interface{}{"a b": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "c": []interface{}{[]byte("x")}},
+			expected: map[string]interface{}{"a b": "2024-01-01", "c": []interface{}{"eA=="}},
+		},
+		{
+			name:     "map with integer keys",
+			typeName: "MAP(INTEGER, DECIMAL(4,2))",
+			value:    duckdb.Map{int32(1): duckdb.Decimal{Width: 4, Scale: 2, Value: big.NewInt(150)}},
+			expected: map[string]interface{}{"1": "1.50"},
+		},
+		{
+			name:     "map with date keys",
+			typeName: "MAP(DATE, VARCHAR)",
+			value:    duckdb.Map{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC): "x"},
+			expected: map[string]interface{}{"2024-01-01": "x"},
+		},
+		{
+			name:     "union",
+			typeName: "UNION(d DATE, s VARCHAR)",
+			value:    duckdb.Union{Tag: "d", Value: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			expected: map[string]interface{}{"d": "2024-01-01"},
+		},
+		{
+			name:     "json",
+			typeName: "JSON",
+			value:    map[string]interface{}{"x": []interface{}{1.0, "y"}},
+			expected: map[string]interface{}{"x": []interface{}{1.0, "y"}},
+		},
+		{name: "unparseable type falls back to go type", typeName: "STRUCT(", value: []byte("x"), expected: "eA=="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, results.EncodeValue(tt.typeName, tt.value))
+		})
+	}
+}
+
+func TestQueryTypeFaithfulJSON(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	columns := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{expr: "170141183460469231731687303715884105727::HUGEINT", expected: "170141183460469231731687303715884105727"},
+		{expr: "9007199254740993::BIGINT", expected: json.Number("9007199254740993")},
+		{expr: "'NaN'::DOUBLE", expected: "NaN"},
+		{expr: "'-inf'::FLOAT", expected: "-Infinity"},
+		{expr: "123.450::DECIMAL(10,3)", expected: "123.450"},
+		{expr: "'a\\x00b'::BLOB", expected: "YQBi"},
+		{expr: "'46bf9e52-2f62-493b-8961-df8102023d8c'::UUID", expected: "46bf9e52-2f62-493b-8961-df8102023d8c"},
+		{expr: "DATE '2024-02-29'", expected: "2024-02-29"},
+		{expr: "TIME '13:14:15.5'", expected: "13:14:15.5"},
+		{expr: "TIMESTAMP '2024-01-02 03:04:05'", expected: "2024-01-02T03:04:05Z"},
+		{expr: "TIMESTAMPTZ '2024-01-02 03:04:05+05'", expected: "2024-01-01T22:04:05Z"},
+		{expr: "INTERVAL '1 year 2 months 3 days 04:05:06.789'", expected: "P1Y2M3DT4H5M6.789S"},
+		{expr: "[DATE '2024-01-01', NULL]", expected: []interface{}{"2024-01-01", nil}},
+		{expr: "{'a': 1.5::DECIMAL(3,1), 'b': [TIMESTAMP '2024-01-01']}", expected: map[string]interface{}{"a": "1.5", "b": []interface{}{"2024-01-01T00:00:00Z"}}},
+		{expr: "MAP {1: 'x'}", expected: map[string]interface{}{"1": "x"}},
+		{expr: "union_value(num := 2)::UNION(num INTEGER, str VARCHAR)", expected: map[string]interface{}{"num": json.Number("2")}},
+		{expr: "NULL::INTEGER", expected: nil},
+	}
+
+	sql := "SELECT "
+	for i, col := range columns {
+		if i > 0 {
+			sql += ", "
+		}
+		sql += col.expr
+	}
+	body, _ := json.Marshal(models.QueryRequest{SQL: sql})
+
+	req := httptest.NewRequest("POST", "/query", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response models.QueryResponse
+	dec := json.NewDecoder(w.Body)
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&response))
+	require.Len(t, response.Rows, 1)
+
+	for i, col := range columns {
+		assert.Equal(t, col.expected, response.Rows[0][i], col.expr)
+	}
+}
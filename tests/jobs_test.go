@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowSQL takes far longer than any test and is only ever cancelled.
+const slowSQL = "SELECT sum(a.range * b.range) FROM range(1000000) a, range(1000000) b"
+
+func setupJobRouter(t *testing.T, db *database.DB, cfg jobs.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RecoveryMiddleware())
+
+	manager := jobs.NewManager(db, cfg)
+	t.Cleanup(manager.Close)
+
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, nil)
+	jobHandler := handlers.NewJobHandler(manager, queryHandler)
+	router.POST("/jobs", jobHandler.Submit)
+	router.GET("/jobs/:id", jobHandler.Status)
+	router.GET("/jobs/:id/result", jobHandler.Result)
+	router.DELETE("/jobs/:id", jobHandler.Delete)
+
+	return router
+}
+
+func defaultJobConfig() jobs.Config {
+	return jobs.Config{Workers: 2, QueueSize: 10, MaxJobs: 10, Timeout: time.Minute, ResultTTL: time.Minute}
+}
+
+func submitJob(t *testing.T, router *gin.Engine, body models.QueryRequest) models.JobStatus {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/jobs", bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var status models.JobStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "/jobs/"+status.ID, w.Header().Get("Location"))
+	return status
+}
+
+func getJob(t *testing.T, router *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+// waitForJob polls a job until it reaches one of the given states.
+func waitForJob(t *testing.T, router *gin.Engine, id string, states ...string) models.JobStatus {
+	t.Helper()
+	var status models.JobStatus
+	require.Eventually(t, func() bool {
+		w := getJob(t, router, "GET", "/jobs/"+id)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		for _, state := range states {
+			if status.State == state {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond)
+	return status
+}
+
+func TestJobLifecycle(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, defaultJobConfig())
+
+	submitted := submitJob(t, router, models.QueryRequest{
+		SQL:    "SELECT id, name, 1.50::DECIMAL(4,2) AS price FROM test_table WHERE id >= $1 ORDER BY id",
+		Params: json.RawMessage(`[1]`),
+	})
+	assert.Contains(t, []string{jobs.StateQueued, jobs.StateRunning, jobs.StateSucceeded}, submitted.State)
+
+	status := waitForJob(t, router, submitted.ID, jobs.StateSucceeded, jobs.StateFailed)
+	require.Equal(t, jobs.StateSucceeded, status.State, status.Error)
+	require.NotNil(t, status.RowCount)
+	assert.Equal(t, int64(2), *status.RowCount)
+	assert.NotNil(t, status.ExpiresAt)
+	assert.NotEmpty(t, status.Elapsed)
+
+	t.Run("json result", func(t *testing.T) {
+		w := getJob(t, router, "GET", "/jobs/"+submitted.ID+"/result")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response models.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []string{"id", "name", "price"}, response.Columns)
+		assert.Equal(t, "DECIMAL(4,2)", response.Schema[2].Type)
+		assert.Equal(t, [][]interface{}{{1.0, "test", "1.50"}, {2.0, "example", "1.50"}}, response.Rows)
+	})
+
+	t.Run("result can be read again in another format", func(t *testing.T) {
+		w := getJob(t, router, "GET", "/jobs/"+submitted.ID+"/result?format=csv&filename=report")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "id,name,price\r\n1,test,1.50\r\n2,example,1.50\r\n", w.Body.String())
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "report.csv")
+	})
+
+	t.Run("ndjson via accept header", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/jobs/"+submitted.ID+"/result", nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		require.Len(t, lines, 4)
+		assert.Contains(t, lines[3], `"count":2`)
+	})
+
+	t.Run("delete discards the result", func(t *testing.T) {
+		w := getJob(t, router, "DELETE", "/jobs/"+submitted.ID)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		assert.Equal(t, http.StatusNotFound, getJob(t, router, "GET", "/jobs/"+submitted.ID).Code)
+		assert.Equal(t, http.StatusNotFound, getJob(t, router, "GET", "/jobs/"+submitted.ID+"/result").Code)
+	})
+}
+
+func TestJobFailure(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, defaultJobConfig())
+
+	submitted := submitJob(t, router, models.QueryRequest{SQL: "SELECT * FROM missing_table"})
+	status := waitForJob(t, router, submitted.ID, jobs.StateSucceeded, jobs.StateFailed)
+	assert.Equal(t, jobs.StateFailed, status.State)
+	assert.Contains(t, status.Error, "missing_table")
+	assert.Nil(t, status.RowCount)
+
+	w := getJob(t, router, "GET", "/jobs/"+submitted.ID+"/result")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "Job failed")
+}
+
+func TestJobCancel(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, defaultJobConfig())
+
+	submitted := submitJob(t, router, models.QueryRequest{SQL: slowSQL})
+	waitForJob(t, router, submitted.ID, jobs.StateRunning)
+
+	w := getJob(t, router, "GET", "/jobs/"+submitted.ID+"/result")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "Job is running")
+
+	w = getJob(t, router, "DELETE", "/jobs/"+submitted.ID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var status models.JobStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, jobs.StateCancelled, status.State)
+
+	assert.Equal(t, http.StatusNotFound, getJob(t, router, "GET", "/jobs/"+submitted.ID).Code)
+}
+
+func TestJobTimeout(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	cfg := defaultJobConfig()
+	cfg.Timeout = 200 * time.Millisecond
+	router := setupJobRouter(t, db, cfg)
+
+	submitted := submitJob(t, router, models.QueryRequest{SQL: slowSQL})
+	status := waitForJob(t, router, submitted.ID, jobs.StateSucceeded, jobs.StateFailed)
+	assert.Equal(t, jobs.StateFailed, status.State)
+	assert.Contains(t, status.Error, "deadline exceeded")
+}
+
+func TestJobQueueFull(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, jobs.Config{Workers: 1, QueueSize: 1, MaxJobs: 10, Timeout: time.Minute, ResultTTL: time.Minute})
+
+	running := submitJob(t, router, models.QueryRequest{SQL: slowSQL})
+	waitForJob(t, router, running.ID, jobs.StateRunning)
+
+	queued := submitJob(t, router, models.QueryRequest{SQL: "SELECT 1"})
+	assert.Equal(t, jobs.StateQueued, queued.State)
+
+	b, _ := json.Marshal(models.QueryRequest{SQL: "SELECT 2"})
+	req := httptest.NewRequest("POST", "/jobs", bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "job queue is full")
+
+	// Cancelling the running job lets the queued one run.
+	getJob(t, router, "DELETE", "/jobs/"+running.ID)
+	status := waitForJob(t, router, queued.ID, jobs.StateSucceeded, jobs.StateFailed)
+	assert.Equal(t, jobs.StateSucceeded, status.State)
+}
+
+func TestJobSubmitValidation(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, defaultJobConfig())
+
+	tests := []struct {
+		name        string
+		body        string
+		expectedErr string
+	}{
+		{name: "missing sql", body: `{}`, expectedErr: "Invalid request"},
+		{name: "blank sql", body: `{"sql": "  "}`, expectedErr: "SQL query cannot be empty"},
+		{name: "pagination", body: `{"sql": "SELECT 1", "limit": 10}`, expectedErr: "not supported for jobs"},
+		{name: "unknown format", body: `{"sql": "SELECT 1", "format": "xml"}`, expectedErr: "xml"},
+		{name: "bad params", body: `{"sql": "SELECT ?", "params": [{"type": "nope", "value": 1}]}`, expectedErr: "invalid parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/jobs", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedErr)
+		})
+	}
+
+	assert.Equal(t, http.StatusNotFound, getJob(t, router, "GET", "/jobs/unknown").Code)
+	assert.Equal(t, http.StatusNotFound, getJob(t, router, "DELETE", "/jobs/unknown").Code)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signingKey is a private key with its JWT algorithm and key ID.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func newSigningKeys(t *testing.T) []signingKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return []signingKey{
+		{kid: "rsa-1", method: jwt.SigningMethodRS256, key: rsaKey},
+		{kid: "ec-1", method: jwt.SigningMethodES256, key: ecKey},
+		{kid: "ed-1", method: jwt.SigningMethodEdDSA, key: edKey},
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwksJSON returns the public JWKS of keys.
+func jwksJSON(t *testing.T, keys ...signingKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		jwk := map[string]string{"kid": k.kid, "use": "sig"}
+		switch pub := k.key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk["kty"], jwk["crv"] = "EC", "P-256"
+			jwk["x"], jwk["y"] = b64(pub.X.FillBytes(make([]byte, 32))), b64(pub.Y.FillBytes(make([]byte, 32)))
+		case ed25519.PublicKey:
+			jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", b64(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func writeJWKS(t *testing.T, path string, keys ...signingKey) {
+	require.NoError(t, os.WriteFile(path, jwksJSON(t, keys...), 0o644))
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "alice",
+		"iss": "https://idp.example.com/",
+		"aud": "goduck",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"realm_access": map[string]interface{}{
+			"roles": []string{"analyst", "unmapped"},
+		},
+	}
+}
+
+func signToken(t *testing.T, k signingKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func newTestValidator(jwks *auth.JWKS) *auth.JWTValidator {
+	return auth.NewJWTValidator(auth.JWTConfig{
+		JWKS:       jwks,
+		Issuer:     "https://idp.example.com/",
+		Audience:   "goduck",
+		Leeway:     5 * time.Second,
+		NameClaim:  "sub",
+		RolesClaim: "realm_access.roles",
+		RoleScopes: map[string][]string{
+			"analyst": {auth.ScopeQueryRead},
+			"ops":     {auth.ScopeAdmin, auth.ScopeMetrics},
+		},
+	})
+}
+
+func TestJWTValidation(t *testing.T) {
+	keys := newSigningKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys...)
+
+	jwks := auth.NewJWKSFile(path, time.Minute)
+	require.NoError(t, jwks.Load(context.Background()))
+	validator := newTestValidator(jwks)
+	ctx := context.Background()
+
+	for _, k := range keys {
+		t.Run("valid "+k.method.Alg(), func(t *testing.T) {
+			p, err := validator.Authenticate(ctx, signToken(t, k, validClaims()))
+			require.NoError(t, err)
+			assert.Equal(t, "alice", p.Name)
+			assert.Equal(t, []string{"analyst", "unmapped"}, p.Roles)
+			assert.Equal(t, []string{auth.ScopeQueryRead}, p.Scopes)
+			assert.Equal(t, "goduck", p.Claims["aud"])
+		})
+	}
+
+	other := newSigningKeys(t)
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	hmac.Header["kid"] = "rsa-1"
+	hmacToken, err := hmac.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	rejected := map[string]string{
+		"expired":         signToken(t, keys[0], with("exp", time.Now().Add(-time.Minute).Unix())),
+		"missing exp":     signToken(t, keys[0], with("exp", nil)),
+		"not yet valid":   signToken(t, keys[0], with("nbf", time.Now().Add(time.Minute).Unix())),
+		"wrong audience":  signToken(t, keys[0], with("aud", "other-service")),
+		"wrong issuer":    signToken(t, keys[0], with("iss", "https://evil.example.com/")),
+		"missing subject": signToken(t, keys[0], with("sub", nil)),
+		"unknown key":     signToken(t, signingKey{kid: "rsa-9", method: other[0].method, key: other[0].key}, validClaims()),
+		"forged key":      signToken(t, signingKey{kid: "rsa-1", method: other[0].method, key: other[0].key}, validClaims()),
+		"algorithm swap":  signToken(t, signingKey{kid: "rsa-1", method: other[1].method, key: other[1].key}, validClaims()),
+		"hmac":            hmacToken,
+		"unsigned":        unsigned,
+		"not a jwt":       "reader-key",
+	}
+	for name, token := range rejected {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := validator.Authenticate(ctx, token)
+			assert.ErrorIs(t, err, auth.ErrInvalidCredential)
+		})
+	}
+
+	t.Run("leeway", func(t *testing.T) {
+		_, err := validator.Authenticate(ctx, signToken(t, keys[0], with("exp", time.Now().Add(-2*time.Second).Unix())))
+		assert.NoError(t, err)
+	})
+}
+
+func TestJWKSRotation(t *testing.T) {
+	keys := newSigningKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys[0])
+
+	jwks := auth.NewJWKSFile(path, time.Second)
+	require.NoError(t, jwks.Load(context.Background()))
+	validator := newTestValidator(jwks)
+
+	_, err := validator.Authenticate(context.Background(), signToken(t, keys[1], validClaims()))
+	assert.Error(t, err)
+
+	// The IdP publishes a new key; tokens signed with it are accepted once
+	// the key set may be reloaded.
+	writeJWKS(t, path, keys[0], keys[1])
+	time.Sleep(1100 * time.Millisecond)
+
+	_, err = validator.Authenticate(context.Background(), signToken(t, keys[1], validClaims()))
+	assert.NoError(t, err)
+}
+
+func TestJWKSURLCaching(t *testing.T) {
+	keys := newSigningKeys(t)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(jwksJSON(t, keys...))
+	}))
+	defer server.Close()
+
+	jwks := auth.NewJWKSURL(server.URL, time.Hour)
+	require.NoError(t, jwks.Load(context.Background()))
+	validator := newTestValidator(jwks)
+
+	for i := 0; i < 5; i++ {
+		_, err := validator.Authenticate(context.Background(), signToken(t, keys[i%len(keys)], validClaims()))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestJWKSRefreshDoesNotBlock(t *testing.T) {
+	keys := newSigningKeys(t)
+	var fetches atomic.Int32
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			w.Write(jwksJSON(t, keys[0]))
+			return
+		}
+		<-unblock
+		w.Write(jwksJSON(t, keys...))
+	}))
+	defer server.Close()
+
+	jwks := auth.NewJWKSURL(server.URL, 50*time.Millisecond)
+	require.NoError(t, jwks.Load(context.Background()))
+	validator := newTestValidator(jwks)
+	time.Sleep(60 * time.Millisecond)
+
+	// Tokens naming a rotated-in key wait for the one reload.
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := validator.Authenticate(context.Background(), signToken(t, keys[1], validClaims()))
+			results <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+
+	// Meanwhile, cached keys keep verifying tokens, and waiting requests
+	// give up with their context.
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := validator.Authenticate(ctx, signToken(t, keys[0], validClaims()))
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = validator.Authenticate(ctx, signToken(t, keys[1], validClaims()))
+	assert.Error(t, err)
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, <-results)
+	}
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	keys := newSigningKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys...)
+	jwks := auth.NewJWKSFile(path, time.Minute)
+	require.NoError(t, jwks.Load(context.Background()))
+
+	apiKeys, err := auth.LoadKeys(writeKeyFile(t, map[string][]string{"reader-key": {auth.ScopeQueryRead}}))
+	require.NoError(t, err)
+
+	router := setupAuthRouter(t, db, auth.Chain{apiKeys, newTestValidator(jwks)})
+	selectQuery := models.QueryRequest{SQL: "SELECT * FROM test_table"}
+
+	w := authRequest(router, "POST", "/query", selectQuery, "Authorization", "Bearer "+signToken(t, keys[2], validClaims()))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = authRequest(router, "POST", "/query", selectQuery, "Authorization", "Bearer reader-key")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = authRequest(router, "GET", "/admin/queries", nil, "Authorization", "Bearer "+signToken(t, keys[2], validClaims()))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	w = authRequest(router, "POST", "/query", selectQuery, "Authorization", "Bearer "+signToken(t, keys[2], claims))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, models.CodeInvalidCredentials, errorCode(t, w))
+}
+
+func TestParseRoleScopes(t *testing.T) {
+	grants, err := auth.ParseRoleScopes("analyst=query:read; dba=query:read,query:write,admin")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"analyst": {"query:read"},
+		"dba":     {"query:read", "query:write", "admin"},
+	}, grants)
+
+	_, err = auth.ParseRoleScopes("analyst=query:everything")
+	assert.Error(t, err)
+	_, err = auth.ParseRoleScopes("analyst")
+	assert.Error(t, err)
+}
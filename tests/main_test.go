@@ -8,9 +8,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lab1702/goduck/internal/cursor"
 	"github.com/lab1702/goduck/internal/database"
 	"github.com/lab1702/goduck/internal/handlers"
 	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/internal/statements"
 	"github.com/lab1702/goduck/pkg/models"
 
 	"github.com/gin-gonic/gin"
@@ -23,7 +26,7 @@ func setupTestDB(t *testing.T) (*database.DB, string) {
 	dbPath := ":memory:"
 
 	// First create a writable connection to set up test data
-	writeDB, err := database.NewDB(dbPath, 1, true)
+	writeDB, err := database.NewDB(dbPath, 1, true, nil, nil)
 	if err != nil {
 		t.Skip("DuckDB not available in test environment, skipping database tests")
 		return nil, ""
@@ -50,13 +53,26 @@ func setupTestRouter(db *database.DB) *gin.Engine {
 	router := gin.New()
 	router.Use(middleware.RecoveryMiddleware())
 
-	queryHandler := handlers.NewQueryHandler(db, 10*time.Second)
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select,explain"), nil, nil)
 	router.POST("/query", queryHandler.ExecuteQuery)
 	router.GET("/health", queryHandler.Health)
 
 	return router
 }
 
+// testPolicy returns a statement policy allowing the given classes.
+func testPolicy(db *database.DB, allowed string) *statements.Policy {
+	classifier, err := statements.NewClassifier(db)
+	if err != nil {
+		panic(err)
+	}
+	classes, err := statements.ParseClasses(allowed)
+	if err != nil {
+		panic(err)
+	}
+	return statements.NewPolicy(classifier, classes)
+}
+
 func TestQueryEndpoint(t *testing.T) {
 	db, _ := setupTestDB(t)
 	defer db.Close()
@@ -143,13 +159,13 @@ func TestHealthEndpoint(t *testing.T) {
 
 func TestDatabaseReadOnlyValidation(t *testing.T) {
 	// Test that in-memory database requires read-write mode
-	_, err := database.NewDB("", 1, false) // in-memory with read-only should fail
+	_, err := database.NewDB("", 1, false, nil, nil) // in-memory with read-only should fail
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "in-memory database requires read-write access")
 	assert.Contains(t, err.Error(), "GODUCK_READ_WRITE=true")
 
 	// Test that in-memory database works with read-write
-	db, err := database.NewDB("", 1, true) // in-memory with read-write should work
+	db, err := database.NewDB("", 1, true, nil, nil) // in-memory with read-write should work
 	if err != nil {
 		t.Skip("DuckDB not available in test environment")
 		return
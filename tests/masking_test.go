@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var maskingPrincipals = staticAuthenticator{
+	"support": {Name: "sue", Roles: []string{"support"}, Scopes: []string{auth.ScopeQueryRead}},
+	"admin":   {Name: "ada", Roles: []string{"admin"}, Scopes: []string{auth.ScopeQueryRead}},
+}
+
+const testMaskingPolicy = `{
+  "rules": [
+    {"table": "customers", "columns": ["email"], "roles": ["support"], "effect": "mask", "mask": "hash"},
+    {"table": "customers", "columns": ["phone"], "roles": ["support"], "effect": "mask", "mask": "partial"},
+    {"table": "customers", "columns": ["ssn"], "roles": ["support"], "effect": "mask", "mask": "null"},
+    {"table": "customers", "columns": ["balance"], "roles": ["support"], "effect": "mask", "mask": "round", "digits": -2}
+  ]
+}`
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestColumnMasking(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+	for _, sql := range []string{
+		`CREATE TABLE customers AS SELECT * FROM (VALUES
+			(1, 'Ann', 'ann@example.com', '555-867-1234', '123-45-6789', 1234),
+			(2, 'Bob', 'bob@example.com', NULL, '987-65-4321', 5678),
+			(3, 'Cy', 'ann@example.com', '42', NULL, 49)
+		) v(id, name, email, phone, ssn, balance)`,
+		"CREATE VIEW customer_view AS SELECT * FROM customers",
+		"CREATE VIEW customer_names AS SELECT id, name FROM customers",
+	} {
+		_, err := db.GetConnection().Exec(sql)
+		require.NoError(t, err, sql)
+	}
+
+	router := setupAccessRouter(t, db, newTestEnforcer(t, db, testMaskingPolicy), maskingPrincipals)
+
+	t.Run("masked values", func(t *testing.T) {
+		rows := queryRows(t, router, "support", "SELECT name, email, phone, ssn, balance FROM customers ORDER BY id")
+		assert.Equal(t, [][]interface{}{
+			{"Ann", sha256Hex("ann@example.com"), "****1234", nil, 1200.0},
+			{"Bob", sha256Hex("bob@example.com"), nil, nil, 5700.0},
+			{"Cy", sha256Hex("ann@example.com"), "****", nil, 0.0},
+		}, rows)
+	})
+
+	t.Run("unmasked for other roles", func(t *testing.T) {
+		rows := queryRows(t, router, "admin", "SELECT email, phone, ssn, balance FROM customers WHERE id = 1")
+		assert.Equal(t, [][]interface{}{{"ann@example.com", "555-867-1234", "123-45-6789", 1234.0}}, rows)
+	})
+
+	tests := []struct {
+		name string
+		sql  string
+		rows [][]interface{}
+	}{
+		{"predicates see masked values", "SELECT id FROM customers WHERE phone = '555-867-1234' OR email LIKE 'ann%' OR ssn IS NOT NULL", nil},
+		{"star", "SELECT * EXCLUDE (id, name) FROM customers WHERE id = 1", [][]interface{}{{sha256Hex("ann@example.com"), "****1234", nil, 1200.0}}},
+		{"hashes still join", "SELECT count(*) FROM customers a JOIN customers b ON a.email = b.email", [][]interface{}{{5.0}}},
+		{"aggregates", "SELECT max(balance) FROM customers", [][]interface{}{{5700.0}}},
+		{"cte", "WITH c AS (SELECT phone FROM customers WHERE id = 1) SELECT phone FROM c", [][]interface{}{{"****1234"}}},
+		{"unmasked columns", "SELECT name FROM customers WHERE id = 2", [][]interface{}{{"Bob"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.rows, queryRows(t, router, "support", tt.sql))
+		})
+	}
+
+	t.Run("views over masked tables are refused", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT phone FROM customer_view"}, "X-API-Key", "support")
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.Equal(t, models.CodeAccessDenied, errorCode(t, w))
+
+		// Views not reading masked columns are unaffected.
+		w = authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT name FROM customer_names"}, "X-API-Key", "support")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/metrics"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/ratelimit"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape fetches the Prometheus metrics and returns the value of each
+// series, keyed by its name and labels as exposed.
+func scrape(t *testing.T, router *gin.Engine) map[string]float64 {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		require.NoError(t, err, line)
+		series[line[:i]] = value
+	}
+	return series
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.MetricsMiddleware())
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, nil)
+	api := router.Group("/", middleware.AuditMiddleware(nil, metrics.ObserveStatement))
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.GET("/metrics", queryHandler.Metrics)
+	limited := router.Group("/limited", middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 1, Burst: 1}, nil).Middleware())
+	limited.GET("", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	const (
+		succeeded  = `goduck_queries_total{class="select",status="success"}`
+		denied     = `goduck_queries_total{class="ddl",status="denied"}`
+		rows       = `goduck_query_rows_returned_sum{class="select"}`
+		latency    = `goduck_query_duration_seconds_count{class="select"}`
+		bytes      = `goduck_http_response_bytes_total{route="/query"}`
+		requests   = `goduck_http_requests_total{code="200",method="POST",route="/query"}`
+		rateLimits = `goduck_rate_limited_total`
+	)
+	before := scrape(t, router)
+
+	w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT * FROM range(3)"}, "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = authRequest(router, "POST", "/query", models.QueryRequest{SQL: "CREATE TABLE t (a INT)"}, "", "")
+	require.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	for i := 0; i < 2; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/limited", nil))
+	}
+
+	after := scrape(t, router)
+	assert.Equal(t, 1.0, after[succeeded]-before[succeeded])
+	assert.Equal(t, 1.0, after[denied]-before[denied])
+	assert.Equal(t, 3.0, after[rows]-before[rows])
+	assert.Equal(t, 1.0, after[latency]-before[latency])
+	assert.Equal(t, 1.0, after[requests]-before[requests])
+	assert.Greater(t, after[bytes]-before[bytes], 0.0)
+	assert.Equal(t, 1.0, after[rateLimits]-before[rateLimits])
+
+	for _, name := range []string{
+		`go_sql_wait_count_total{db_name="goduck"}`,
+		`go_sql_wait_duration_seconds_total{db_name="goduck"}`,
+		`go_sql_max_open_connections{db_name="goduck"}`,
+		`go_goroutines`,
+		`go_memstats_alloc_bytes`,
+	} {
+		assert.Contains(t, after, name)
+	}
+
+	t.Run("JSON view", func(t *testing.T) {
+		for _, path := range []string{"/metrics", "/metrics?format=json"} {
+			w := authRequest(router, "GET", path, nil, "", "")
+			require.Equal(t, http.StatusOK, w.Code)
+			var response handlers.MetricsResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Positive(t, response.Goroutines)
+		}
+
+		w := authRequest(router, "GET", "/metrics?format=prometheus", nil, "", "")
+		assert.Contains(t, w.Body.String(), "# TYPE goduck_queries_total counter")
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/config"
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postQuery(t *testing.T, router *gin.Engine, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/query", bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestQueryPagination(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	// The sequence is written to a table so that a re-run of the query would
+	// be visible: pages must come from the stored result.
+	_, err := db.GetConnection().Exec("CREATE TABLE seq AS SELECT range AS n FROM range(7)")
+	require.NoError(t, err)
+
+	w := postQuery(t, router, models.QueryRequest{SQL: "SELECT n FROM seq ORDER BY n", Limit: 3})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var first models.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Equal(t, 3, first.Count)
+	assert.Equal(t, [][]interface{}{{0.0}, {1.0}, {2.0}}, first.Rows)
+	require.NotEmpty(t, first.NextPageToken)
+
+	_, err = db.GetConnection().Exec("DELETE FROM seq")
+	require.NoError(t, err)
+
+	var seen [][]interface{}
+	token := first.NextPageToken
+	for token != "" {
+		w := postQuery(t, router, models.QueryRequest{PageToken: token})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page models.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, []string{"n"}, page.Columns)
+		assert.Equal(t, "BIGINT", page.Schema[0].Type)
+		seen = append(seen, page.Rows...)
+		token = page.NextPageToken
+	}
+	assert.Equal(t, [][]interface{}{{3.0}, {4.0}, {5.0}, {6.0}}, seen)
+
+	t.Run("page tokens can be replayed and resized", func(t *testing.T) {
+		w := postQuery(t, router, models.QueryRequest{PageToken: first.NextPageToken, Limit: 10})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page models.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, 4, page.Count)
+		assert.Empty(t, page.NextPageToken)
+	})
+}
+
+func TestQueryPaginationSinglePage(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	w := postQuery(t, router, models.QueryRequest{SQL: "SELECT * FROM test_table", Limit: 2})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response models.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Count)
+	assert.NotContains(t, w.Body.String(), "next_page_token")
+}
+
+func TestQueryPaginationErrors(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	w := postQuery(t, router, models.QueryRequest{SQL: "SELECT * FROM range(5)", Limit: 1})
+	require.Equal(t, http.StatusOK, w.Code)
+	var first models.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+
+	tests := []struct {
+		name         string
+		body         models.QueryRequest
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "negative limit",
+			body:         models.QueryRequest{SQL: "SELECT 1", Limit: -1},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "limit must not be negative",
+		},
+		{
+			name:         "limit with streaming format",
+			body:         models.QueryRequest{SQL: "SELECT 1", Limit: 1, Format: "ndjson"},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "only supported for JSON",
+		},
+		{
+			name:         "malformed token",
+			body:         models.QueryRequest{PageToken: "not a token"},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "invalid page token",
+		},
+		{
+			name:         "unknown token",
+			body:         models.QueryRequest{PageToken: cursor.Token{ID: "missing", Offset: 1}.String()},
+			expectedCode: http.StatusNotFound,
+			expectedErr:  "not found or expired",
+		},
+		{
+			name:         "token for another query",
+			body:         models.QueryRequest{SQL: "SELECT 2", PageToken: first.NextPageToken},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "different query",
+		},
+		{
+			name:         "result larger than the page store",
+			body:         models.QueryRequest{SQL: "SELECT * FROM range(2000)", Limit: 10},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "Result too large to page",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postQuery(t, router, tt.body)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			var response models.ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Contains(t, response.Error, tt.expectedErr)
+		})
+	}
+}
+
+func TestCursorStoreEviction(t *testing.T) {
+	store := cursor.NewStore(time.Minute, 2, 100, 200)
+	defer store.Close()
+
+	first, err := store.Put(&cursor.Result{SQL: "a"})
+	require.NoError(t, err)
+	_, err = store.Put(&cursor.Result{SQL: "b"})
+	require.NoError(t, err)
+	_, err = store.Put(&cursor.Result{SQL: "c"})
+	require.NoError(t, err)
+
+	_, ok := store.Get(first)
+	assert.False(t, ok, "oldest result should be evicted")
+}
+
+func TestCursorStoreTotalRows(t *testing.T) {
+	store := cursor.NewStore(time.Minute, 10, 100, 200)
+	defer store.Close()
+
+	rows := func(n int) [][]interface{} {
+		return make([][]interface{}, n)
+	}
+	first, err := store.Put(&cursor.Result{SQL: "a", Rows: rows(100)})
+	require.NoError(t, err)
+	second, err := store.Put(&cursor.Result{SQL: "b", Rows: rows(50)})
+	require.NoError(t, err)
+	third, err := store.Put(&cursor.Result{SQL: "c", Rows: rows(100)})
+	require.NoError(t, err)
+
+	_, ok := store.Get(first)
+	assert.False(t, ok, "oldest result should be evicted to stay within the total")
+	for _, id := range []string{second, third} {
+		_, ok := store.Get(id)
+		assert.True(t, ok)
+	}
+}
+
+func TestCursorConfig(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 100000, cfg.MaxCursorRows)
+	assert.Equal(t, 1000000, cfg.MaxCursorTotalRows)
+
+	t.Setenv("GODUCK_MAX_CURSOR_ROWS", "2000000")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "MAX_CURSOR_TOTAL_ROWS must be at least MAX_CURSOR_ROWS")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/params"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []interface{}
+		errParam string
+	}{
+		{name: "absent", raw: "", expected: nil},
+		{name: "null", raw: "null", expected: nil},
+		{
+			name:     "positional scalars",
+			raw:      `[1, 2.5, "x", true, null]`,
+			expected: []interface{}{int64(1), 2.5, "x", true, nil},
+		},
+		{
+			name:     "named",
+			raw:      `{"$id": 7}`,
+			expected: []interface{}{sql.Named("id", int64(7))},
+		},
+		{
+			name:     "typed timestamp",
+			raw:      `[{"type": "timestamp", "value": "2025-01-02T03:04:05Z"}]`,
+			expected: []interface{}{time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+		{
+			name:     "typed date",
+			raw:      `[{"type": "date", "value": "2025-01-02"}]`,
+			expected: []interface{}{time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "typed float from integer",
+			raw:      `[{"type": "float", "value": 3}]`,
+			expected: []interface{}{float64(3)},
+		},
+		{
+			name:     "list literal",
+			raw:      `[[1, "a\"b", null, [2]]]`,
+			expected: []interface{}{`[1, "a\"b", NULL, [2]]`},
+		},
+		{name: "bad timestamp", raw: `[1, {"type": "timestamp", "value": "yesterday"}]`, errParam: "$2"},
+		{name: "integer overflow", raw: `{"n": {"type": "integer", "value": 1e30}}`, errParam: "$n"},
+		{name: "unknown type", raw: `[{"type": "uuid", "value": "x"}]`, errParam: "$1"},
+		{name: "bad list element", raw: `[[1, {"type": "boolean", "value": 1}]]`, errParam: "$1[1]"},
+		{name: "bad name", raw: `{"1st": 1}`, errParam: "$1st"},
+		{name: "scalar params", raw: `42`, errParam: "params"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := params.Parse(json.RawMessage(tt.raw))
+			if tt.errParam != "" {
+				var perr *params.Error
+				require.ErrorAs(t, err, &perr)
+				assert.Equal(t, tt.errParam, perr.Param)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, params.Resolve(args, nil))
+		})
+	}
+}
+
+func TestResolveListParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected interface{}
+	}{
+		{name: "integers", raw: `[[1, 2, 3]]`, expected: []int64{1, 2, 3}},
+		{name: "integers and fractions", raw: `[[1, 2.5]]`, expected: []float64{1, 2.5}},
+		{name: "strings", raw: `[["a", "b"]]`, expected: []string{"a", "b"}},
+		{name: "booleans", raw: `[[true, false]]`, expected: []bool{true, false}},
+		{
+			name:     "typed elements",
+			raw:      `[[{"type": "date", "value": "2025-01-02"}]]`,
+			expected: []time.Time{time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{name: "nested", raw: `[[[1], [2, 3], []]]`, expected: [][]int64{{1}, {2, 3}, {}}},
+		{name: "empty", raw: `[[]]`, expected: []int64{}},
+		{name: "null element", raw: `[[1, null]]`, expected: "[1, NULL]"},
+		{name: "mixed types", raw: `[[1, "a"]]`, expected: `[1, "a"]`},
+		{name: "mixed nested types", raw: `[[[1], ["a"]]]`, expected: `[[1], ["a"]]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := params.Parse(json.RawMessage(tt.raw))
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{tt.expected}, params.Resolve(args, map[string]bool{"1": true}))
+		})
+	}
+
+	t.Run("named", func(t *testing.T) {
+		args, err := params.Parse(json.RawMessage(`{"ids": [1, 2], "names": ["a"]}`))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []interface{}{sql.Named("ids", []int64{1, 2}), sql.Named("names", `["a"]`)},
+			params.Resolve(args, map[string]bool{"ids": true}))
+	})
+}
+
+func TestQueryWithParams(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedRows [][]interface{}
+	}{
+		{
+			name:         "positional",
+			body:         `{"sql": "SELECT name FROM test_table WHERE id = ?", "params": [2]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{"example"}},
+		},
+		{
+			name:         "named",
+			body:         `{"sql": "SELECT id FROM test_table WHERE name = $name", "params": {"name": "test"}}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{float64(1)}},
+		},
+		{
+			name:         "numeric list",
+			body:         `{"sql": "SELECT count(*) AS n FROM test_table WHERE id = ANY(?)", "params": [[1, 2, 3]]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{float64(2)}},
+		},
+		{
+			name:         "numeric list in a subquery",
+			body:         `{"sql": "SELECT name FROM test_table WHERE id IN (SELECT unnest($ids))", "params": {"ids": [2, 3]}}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{"example"}},
+		},
+		{
+			name:         "string list",
+			body:         `{"sql": "SELECT id FROM test_table WHERE name = ANY(?)", "params": [["test", "other"]]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{float64(1)}},
+		},
+		{
+			name:         "list cast to a list type",
+			body:         `{"sql": "SELECT count(*) AS n FROM test_table WHERE id = ANY($1::INTEGER[])", "params": [[1, 2, 3]]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{float64(2)}},
+		},
+		{
+			name:         "list with a null",
+			body:         `{"sql": "SELECT count(*) AS n FROM test_table WHERE id = ANY($1::INTEGER[])", "params": [[2, null]]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{float64(1)}},
+		},
+		{
+			name:         "list compared with a list column",
+			body:         `{"sql": "SELECT ? = [1, 2]::INTEGER[] AS eq", "params": [[1, 2]]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: [][]interface{}{{true}},
+		},
+		{
+			name:         "injection attempt is bound as a value",
+			body:         `{"sql": "SELECT id FROM test_table WHERE name = ?", "params": ["x' OR '1'='1"]}`,
+			expectedCode: http.StatusOK,
+			expectedRows: nil,
+		},
+		{
+			name:         "invalid parameter",
+			body:         `{"sql": "SELECT ?", "params": [{"type": "date", "value": "01/02/2025"}]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedCode, w.Code, w.Body.String())
+			if tt.expectedCode != http.StatusOK {
+				var response models.ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Contains(t, response.Error, "$1")
+				return
+			}
+
+			var response models.QueryResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedRows, response.Rows)
+		})
+	}
+}
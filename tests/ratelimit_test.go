@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/ratelimit"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limiter := ratelimit.NewLimiter()
+	limit := ratelimit.Limit{PerMinute: 60, Burst: 3}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 2; i >= 0; i-- {
+		d := limiter.Allow("a", limit, now)
+		require.True(t, d.Allowed)
+		assert.Equal(t, 3, d.Limit)
+		assert.Equal(t, i, d.Remaining)
+	}
+	d := limiter.Allow("a", limit, now)
+	assert.False(t, d.Allowed, "burst exhausted")
+	assert.Equal(t, time.Second, d.RetryAfter)
+	assert.Equal(t, 3*time.Second, d.Reset)
+	assert.True(t, limiter.Allow("b", limit, now).Allowed, "keys have their own bucket")
+
+	d = limiter.Allow("a", limit, now.Add(1500*time.Millisecond))
+	assert.True(t, d.Allowed, "a token is earned every second")
+	assert.Equal(t, 0, d.Remaining)
+	d = limiter.Allow("a", limit, now.Add(1500*time.Millisecond))
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+
+	d = limiter.Allow("a", limit, now.Add(time.Hour))
+	assert.Equal(t, 2, d.Remaining, "the bucket refills up to the burst only")
+
+	assert.True(t, limiter.Allow("a", ratelimit.Limit{}, now).Allowed, "a zero limit is unlimited")
+
+	t.Run("refilled buckets are dropped", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter()
+		for i := 0; i < 1000; i++ {
+			limiter.Allow(fmt.Sprint(i), limit, now)
+		}
+		assert.Equal(t, 1000, limiter.Len())
+		for i := 0; i < 1000; i++ {
+			limiter.Allow(fmt.Sprint("later", i), limit, now.Add(2*time.Minute))
+		}
+		assert.Equal(t, 1000, limiter.Len())
+	})
+}
+
+func TestParseRouteLimits(t *testing.T) {
+	routes, err := ratelimit.ParseRoutes([]string{"/query=30:10", " /jobs = 5 ", "/metrics=0"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ratelimit.Limit{
+		"/query":   {PerMinute: 30, Burst: 10},
+		"/jobs":    {PerMinute: 5, Burst: 5},
+		"/metrics": {},
+	}, routes)
+
+	for _, entry := range []string{"/query", "query=5", "/query=-1", "/query=5:0", "/query=x"} {
+		_, err := ratelimit.ParseRoutes([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 60, Burst: 2}, map[string]ratelimit.Limit{
+		"/query":  {PerMinute: 60, Burst: 1},
+		"/health": {},
+	})
+	api := router.Group("/", middleware.AuthMiddleware(testPrincipals), limiter.Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	api.GET("/jobs", ok)
+	api.GET("/metrics", ok)
+	api.GET("/query", ok)
+	api.GET("/health", ok)
+
+	get := func(router *gin.Engine, path, key, ip string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", key)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Routes without their own limit share the default bucket.
+	assert.Equal(t, http.StatusNoContent, get(router, "/jobs", "analyst", "192.0.2.1"))
+	assert.Equal(t, http.StatusNoContent, get(router, "/metrics", "analyst", "192.0.2.2"))
+	assert.Equal(t, http.StatusTooManyRequests, get(router, "/jobs", "analyst", "192.0.2.3"), "counted by principal, not IP")
+	assert.Equal(t, http.StatusNoContent, get(router, "/jobs", "hr", "192.0.2.1"))
+
+	// Routes with their own limit have their own bucket.
+	assert.Equal(t, http.StatusNoContent, get(router, "/query", "analyst", "192.0.2.1"))
+	assert.Equal(t, http.StatusTooManyRequests, get(router, "/query", "analyst", "192.0.2.1"))
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, http.StatusNoContent, get(router, "/health", "analyst", "192.0.2.1"), "exempt")
+	}
+
+	t.Run("unauthenticated clients are counted by IP", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/", middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 60, Burst: 1}, nil).Middleware(), ok)
+		assert.Equal(t, http.StatusNoContent, get(router, "/", "", "192.0.2.1"))
+		assert.Equal(t, http.StatusTooManyRequests, get(router, "/", "", "192.0.2.1"))
+		assert.Equal(t, http.StatusNoContent, get(router, "/", "", "192.0.2.2"))
+	})
+}
+
+func TestRateLimiterGoroutines(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 6000, Burst: 10000}, nil).Middleware())
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5000; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNoContent, w.Code)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+2)
+}
+
+func BenchmarkRateLimiter(b *testing.B) {
+	limiter := ratelimit.NewLimiter()
+	limit := ratelimit.Limit{PerMinute: 6000, Burst: 100}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("principal:%d", i)
+	}
+
+	before := runtime.NumGoroutine()
+	var n atomic.Uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			limiter.Allow(keys[n.Add(1)%uint64(len(keys))], limit, time.Now())
+		}
+	})
+	b.ReportMetric(float64(runtime.NumGoroutine()-before), "goroutines")
+}
+
+func BenchmarkRateLimiterMiddleware(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 1000000, Burst: 1000000}, nil).Middleware())
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	req := httptest.NewRequest("GET", "/", nil)
+
+	before := runtime.NumGoroutine()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	b.ReportMetric(float64(runtime.NumGoroutine()-before), "goroutines")
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 6, Burst: 2}, map[string]ratelimit.Limit{"/health": {}}).Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/", ok)
+	router.GET("/health", ok)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "10", w.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	w = get("/")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "20", w.Header().Get("RateLimit-Reset"))
+
+	w = get("/")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, models.CodeRateLimited, errorCode(t, w))
+
+	w = get("/health")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"), "exempt routes have no limit to report")
+}
+
+func TestRateLimitAuthFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limiter := middleware.NewRateLimiter(ratelimit.Limit{PerMinute: 60, Burst: 3}, nil)
+	api := router.Group("/", limiter.PreAuthMiddleware(), middleware.AuthMiddleware(testPrincipals), limiter.Middleware())
+	api.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	get := func(key, ip string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Authenticated requests are not counted against the client IP.
+	for _, key := range []string{"analyst", "hr"} {
+		for i := 0; i < 3; i++ {
+			require.Equal(t, http.StatusNoContent, get(key, "192.0.2.1"), key)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get(key, "192.0.2.1"), key)
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusUnauthorized, get("guess", "192.0.2.1"))
+	}
+
+	t.Run("authentication failures carry headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", "guess")
+		req.RemoteAddr = "198.51.100.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1", w.Header().Get("RateLimit-Reset"))
+	})
+	assert.Equal(t, http.StatusTooManyRequests, get("guess", "192.0.2.1"), "guessing keys is limited")
+	assert.Equal(t, http.StatusTooManyRequests, get("finance", "192.0.2.1"), "until the address's bucket refills")
+	assert.Equal(t, http.StatusNoContent, get("finance", "192.0.2.2"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lab1702/goduck/internal/config"
+	"github.com/lab1702/goduck/internal/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLimits(t *testing.T) {
+	tempDir := filepath.Join(t.TempDir(), "spill")
+
+	// The sandbox locks the configuration after the limits are applied.
+	db, err := database.NewDB("", 1, true, &database.Resources{
+		MemoryLimit:          "64MiB",
+		Threads:              2,
+		TempDirectory:        tempDir,
+		MaxTempDirectorySize: "1GiB",
+	}, &database.Sandbox{})
+	require.NoError(t, err)
+	defer db.Close()
+
+	var memoryLimit, threads, directory, maxSize string
+	require.NoError(t, db.GetConnection().QueryRow(`SELECT current_setting('memory_limit'), current_setting('threads')::VARCHAR,
+		current_setting('temp_directory'), current_setting('max_temp_directory_size')`).Scan(&memoryLimit, &threads, &directory, &maxSize))
+	assert.Equal(t, "64.0 MiB", memoryLimit)
+	assert.Equal(t, "2", threads)
+	assert.Equal(t, tempDir, directory)
+	assert.Equal(t, "1.0 GiB", maxSize)
+
+	t.Run("queries beyond the memory limit spill to disk", func(t *testing.T) {
+		var n int
+		require.NoError(t, db.GetConnection().QueryRow(`SELECT count(*) FROM (
+			SELECT i, md5(i::VARCHAR) AS h FROM range(3000000) t(i) ORDER BY h
+		)`).Scan(&n))
+		assert.Equal(t, 3000000, n)
+		assert.DirExists(t, tempDir)
+	})
+
+	t.Run("queries cannot lift the limits", func(t *testing.T) {
+		for _, sql := range []string{
+			"SET memory_limit = '16GB'",
+			"SET threads = 64",
+			"SET temp_directory = '/tmp'",
+		} {
+			_, err := db.GetConnection().Exec(sql)
+			assert.Error(t, err, sql)
+		}
+	})
+
+	t.Run("invalid limits are refused", func(t *testing.T) {
+		_, err := database.NewDB("", 1, true, &database.Resources{MemoryLimit: "lots"}, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestResourceLimitsConfig(t *testing.T) {
+	t.Run("DuckDB defaults", func(t *testing.T) {
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		assert.Empty(t, cfg.MemoryLimit)
+		assert.Zero(t, cfg.Threads)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		t.Setenv("GODUCK_MEMORY_LIMIT", "4GB")
+		t.Setenv("GODUCK_THREADS", "4")
+		t.Setenv("GODUCK_TEMP_DIRECTORY", "/var/tmp/goduck")
+		t.Setenv("GODUCK_MAX_TEMP_DIRECTORY_SIZE", "1.5 TiB")
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		assert.Equal(t, "4GB", cfg.MemoryLimit)
+		assert.Equal(t, 4, cfg.Threads)
+		assert.Equal(t, "/var/tmp/goduck", cfg.TempDirectory)
+		assert.Equal(t, "1.5 TiB", cfg.MaxTempDirectorySize)
+	})
+
+	for name, env := range map[string][2]string{
+		"memory limit without a unit": {"GODUCK_MEMORY_LIMIT", "4096"},
+		"percentage":                  {"GODUCK_MEMORY_LIMIT", "80%"},
+		"negative threads":            {"GODUCK_THREADS", "-1"},
+		"temp directory size":         {"GODUCK_MAX_TEMP_DIRECTORY_SIZE", "lots"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			_, err := config.Load()
+			assert.Error(t, err)
+		})
+	}
+}
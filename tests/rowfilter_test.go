@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var tenantPrincipals = staticAuthenticator{
+	"tenant-a":  {Name: "alice", Roles: []string{"tenant"}, Scopes: []string{auth.ScopeQueryRead}, Claims: map[string]interface{}{"tenant": "a"}},
+	"tenant-b":  {Name: "bert", Roles: []string{"tenant"}, Scopes: []string{auth.ScopeQueryRead}, Claims: map[string]interface{}{"tenant": "b"}},
+	"partner":   {Name: "pat", Roles: []string{"partner"}, Scopes: []string{auth.ScopeQueryRead}, Claims: map[string]interface{}{"org": map[string]interface{}{"tenants": []interface{}{"a", "c"}}}},
+	"admin":     {Name: "ada", Roles: []string{"admin"}, Scopes: []string{auth.ScopeQueryRead}},
+	"unscoped":  {Name: "uma", Roles: []string{"tenant"}, Scopes: []string{auth.ScopeQueryRead}},
+	"injection": {Name: "ivan", Roles: []string{"tenant"}, Scopes: []string{auth.ScopeQueryRead}, Claims: map[string]interface{}{"tenant": "a' OR '1'='1"}},
+}
+
+const testRowFilterPolicy = `{
+  "row_filters": [
+    {"table": "orders", "roles": ["admin"], "filter": "true"},
+    {"table": "orders", "roles": ["partner"], "filter": "list_contains({{claims.org.tenants}}, tenant_id)"},
+    {"table": "orders", "filter": "tenant_id = {{claims.tenant}}"}
+  ]
+}`
+
+// queryRows runs sql as the principal with the given key and returns the
+// result rows.
+func queryRows(t *testing.T, router *gin.Engine, key, sql string) [][]interface{} {
+	t.Helper()
+	w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: sql}, "X-API-Key", key)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response models.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Rows
+}
+
+func TestRowFilters(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+	for _, sql := range []string{
+		"CREATE TABLE orders AS SELECT * FROM (VALUES (1, 'a', 10), (2, 'b', 20), (3, 'a', 30), (4, 'c', 40)) v(id, tenant_id, amount)",
+		"CREATE VIEW orders_view AS SELECT * FROM orders",
+	} {
+		_, err := db.GetConnection().Exec(sql)
+		require.NoError(t, err, sql)
+	}
+
+	router := setupAccessRouter(t, db, newTestEnforcer(t, db, testRowFilterPolicy), tenantPrincipals)
+
+	tests := []struct {
+		name string
+		sql  string
+		rows [][]interface{}
+	}{
+		{"plain", "SELECT id FROM orders ORDER BY id", [][]interface{}{{1.0}, {3.0}}},
+		{"other tenant's rows", "SELECT id FROM orders WHERE tenant_id = 'b'", nil},
+		{"qualified", "SELECT id FROM memory.main.orders ORDER BY id", [][]interface{}{{1.0}, {3.0}}},
+		{"alias", "SELECT o.id FROM orders AS o WHERE o.amount > 10", [][]interface{}{{3.0}}},
+		{"column aliases", "SELECT x FROM orders AS o(x) ORDER BY x", [][]interface{}{{1.0}, {3.0}}},
+		{"qualified columns", "SELECT orders.id FROM orders ORDER BY orders.id", [][]interface{}{{1.0}, {3.0}}},
+		{"self join", "SELECT count(*) FROM orders a, orders b", [][]interface{}{{4.0}}},
+		{"subquery", "SELECT count(*) FROM (SELECT 1) t WHERE EXISTS (SELECT 1 FROM orders WHERE tenant_id = 'b')", [][]interface{}{{0.0}}},
+		{"cte", "WITH o AS (SELECT * FROM orders) SELECT max(amount) FROM o", [][]interface{}{{30.0}}},
+		{"cte shadowing the table", "WITH orders AS (SELECT 'b' AS tenant_id) SELECT tenant_id FROM orders", [][]interface{}{{"b"}}},
+		{"union", "SELECT id FROM orders UNION ALL SELECT id FROM main.orders ORDER BY id", [][]interface{}{{1.0}, {1.0}, {3.0}, {3.0}}},
+		{"lateral column", "SELECT id FROM (SELECT 'b' AS tenant_id) t, orders ORDER BY id", [][]interface{}{{1.0}, {3.0}}},
+		{"sample", "SELECT count(*) FROM orders USING SAMPLE 100%", [][]interface{}{{2.0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.rows, queryRows(t, router, "tenant-a", tt.sql))
+		})
+	}
+
+	t.Run("each principal sees its own rows", func(t *testing.T) {
+		sql := "SELECT id FROM orders ORDER BY id"
+		assert.Equal(t, [][]interface{}{{2.0}}, queryRows(t, router, "tenant-b", sql))
+		assert.Equal(t, [][]interface{}{{1.0}, {3.0}, {4.0}}, queryRows(t, router, "partner", sql))
+		assert.Equal(t, [][]interface{}{{1.0}, {2.0}, {3.0}, {4.0}}, queryRows(t, router, "admin", sql))
+		assert.Nil(t, queryRows(t, router, "injection", sql))
+	})
+
+	t.Run("column names are not rewritten", func(t *testing.T) {
+		sql := "SELECT (SELECT max(amount) FROM orders WHERE tenant_id = 'b'), id + 1 FROM orders UNION ALL SELECT 1, 2"
+		columns := func(key string) []string {
+			w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: sql}, "X-API-Key", key)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var response models.QueryResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			return response.Columns
+		}
+		expected := []string{"(SELECT max(amount) FROM orders WHERE (tenant_id = 'b'))", "(id + 1)"}
+		assert.Equal(t, expected, columns("tenant-a"))
+		assert.Equal(t, expected, columns("admin"))
+	})
+
+	t.Run("parameters", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT id FROM orders WHERE amount > ?", Params: json.RawMessage(`[15]`)}, "X-API-Key", "tenant-a")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"rows":[[3]]`)
+	})
+
+	for name, sql := range map[string]string{
+		"view":           "SELECT * FROM orders_view",
+		"table function": "SELECT * FROM query_table('orders')",
+	} {
+		t.Run(name+" is refused", func(t *testing.T) {
+			w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: sql}, "X-API-Key", "tenant-a")
+			assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+			assert.Equal(t, models.CodeAccessDenied, errorCode(t, w))
+			assert.Contains(t, w.Body.String(), "access policy for table memory.main.orders cannot be applied")
+		})
+	}
+
+	t.Run("missing claim is refused", func(t *testing.T) {
+		w := authRequest(router, "POST", "/query", models.QueryRequest{SQL: "SELECT * FROM orders"}, "X-API-Key", "unscoped")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), `claim \"tenant\" is not set`)
+
+		// Tables without row filters can still be read.
+		assert.Len(t, queryRows(t, router, "unscoped", "SELECT id FROM employees"), 2)
+	})
+
+	t.Run("pages are filtered", func(t *testing.T) {
+		query := models.QueryRequest{SQL: "SELECT id FROM orders ORDER BY id", Limit: 1}
+		w := authRequest(router, "POST", "/query", query, "X-API-Key", "partner")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var page models.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, [][]interface{}{{1.0}}, page.Rows)
+		require.NotEmpty(t, page.NextPageToken)
+
+		// The client resends its own SQL, not the rewritten query.
+		query.PageToken = page.NextPageToken
+		w = authRequest(router, "POST", "/query", query, "X-API-Key", "partner")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, [][]interface{}{{3.0}}, page.Rows)
+
+		w = authRequest(router, "POST", "/query", query, "X-API-Key", "tenant-b")
+		assert.Equal(t, http.StatusNotFound, w.Code, "pages of another principal")
+	})
+
+	t.Run("jobs are filtered", func(t *testing.T) {
+		w := authRequest(router, "POST", "/jobs", models.QueryRequest{SQL: "SELECT id FROM orders ORDER BY id"}, "X-API-Key", "tenant-b")
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+		var submitted models.JobStatus
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &submitted))
+		var status models.JobStatus
+		require.Eventually(t, func() bool {
+			w := authRequest(router, "GET", "/jobs/"+submitted.ID, nil, "X-API-Key", "tenant-b")
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+			return status.State == jobs.StateSucceeded || status.State == jobs.StateFailed
+		}, 10*time.Second, 10*time.Millisecond)
+		require.Equal(t, jobs.StateSucceeded, status.State, status.Error)
+		assert.Equal(t, int64(1), *status.RowCount)
+		assert.Equal(t, "SELECT id FROM orders ORDER BY id", status.SQL, "the row filter is not shown")
+
+		// Other principals cannot tell the job exists.
+		for _, route := range []string{"GET /jobs/%s", "GET /jobs/%s/result", "DELETE /jobs/%s"} {
+			method, path, _ := strings.Cut(fmt.Sprintf(route, submitted.ID), " ")
+			w := authRequest(router, method, path, nil, "X-API-Key", "tenant-a")
+			assert.Equal(t, http.StatusNotFound, w.Code, route)
+		}
+
+		w = authRequest(router, "GET", "/jobs/"+submitted.ID+"/result", nil, "X-API-Key", "tenant-b")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"rows":[[2]]`)
+	})
+}
+
+func TestRowFilterTracing(t *testing.T) {
+	db := setupAccessDB(t)
+	defer db.Close()
+	_, err := db.GetConnection().Exec("CREATE TABLE orders AS SELECT * FROM (VALUES (1, 'a', 10), (2, 'b', 20)) v(id, tenant_id, amount)")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    tracing.ExporterFile,
+		File:        path,
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	router := setupAccessRouter(t, db, newTestEnforcer(t, db, testRowFilterPolicy), tenantPrincipals)
+
+	const sql = "SELECT id FROM orders"
+	assert.Equal(t, [][]interface{}{{1.0}}, queryRows(t, router, "tenant-a", sql))
+
+	w := authRequest(router, "POST", "/jobs", models.QueryRequest{SQL: sql}, "X-API-Key", "tenant-a")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var status models.JobStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Eventually(t, func() bool {
+		w := authRequest(router, "GET", "/jobs/"+status.ID, nil, "X-API-Key", "tenant-a")
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status.State == jobs.StateSucceeded || status.State == jobs.StateFailed
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, jobs.StateSucceeded, status.State, status.Error)
+	require.NoError(t, shutdown(context.Background()))
+
+	// Neither the query nor the job exports the row filter.
+	var queries int
+	for _, span := range readSpans(t, path) {
+		if span.Name == "duckdb.query" {
+			queries++
+			assert.Equal(t, sql, span.attribute("db.query.text"))
+		}
+	}
+	assert.Equal(t, 2, queries)
+}
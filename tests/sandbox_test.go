@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/config"
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/database"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sandboxFiles creates a CSV file inside an allowed directory, a single
+// allowed file, and files outside the allowed roots.
+func sandboxFiles(t *testing.T) (root string) {
+	root = t.TempDir()
+	for _, name := range []string{"allowed/in.csv", "allowed-sibling/out.csv", "single.csv", "other.csv"} {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("id,name\n1,a\n2,b\n"), 0o644))
+	}
+	return root
+}
+
+func TestSandbox(t *testing.T) {
+	root := sandboxFiles(t)
+
+	db, err := database.NewDB("", 1, true, nil, &database.Sandbox{
+		AllowedDirectories: []string{filepath.Join(root, "allowed")},
+		AllowedPaths:       []string{filepath.Join(root, "single.csv")},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	count := func(path string) error {
+		var n int
+		return db.GetConnection().QueryRow("SELECT count(*) FROM read_csv(?)", path).Scan(&n)
+	}
+
+	t.Run("allowed roots are readable", func(t *testing.T) {
+		assert.NoError(t, count(filepath.Join(root, "allowed", "in.csv")))
+		assert.NoError(t, count(filepath.Join(root, "single.csv")))
+	})
+
+	t.Run("files outside the allowed roots are refused", func(t *testing.T) {
+		for _, path := range []string{
+			"/etc/passwd",
+			filepath.Join(root, "other.csv"),
+			filepath.Join(root, "allowed-sibling", "out.csv"),
+			filepath.Join(root, "allowed", "..", "other.csv"),
+			"https://example.com/data.csv",
+			"s3://bucket/data.parquet",
+		} {
+			err := count(path)
+			if assert.Error(t, err, path) {
+				assert.Contains(t, err.Error(), "Permission Error", path)
+			}
+		}
+	})
+
+	t.Run("configuration is locked", func(t *testing.T) {
+		for _, sql := range []string{
+			"SET enable_external_access = true",
+			"SET allowed_directories = ['/']",
+			"SET lock_configuration = false",
+			"SET autoload_known_extensions = true",
+		} {
+			_, err := db.GetConnection().Exec(sql)
+			assert.Error(t, err, sql)
+		}
+	})
+
+	t.Run("extension autoload is disabled", func(t *testing.T) {
+		var autoload bool
+		require.NoError(t, db.GetConnection().QueryRow("SELECT current_setting('autoload_known_extensions')").Scan(&autoload))
+		assert.False(t, autoload)
+	})
+
+	t.Run("queries through the API are refused", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, nil)
+		router.POST("/query", queryHandler.ExecuteQuery)
+
+		w := postQuery(t, router, models.QueryRequest{SQL: "SELECT * FROM read_csv('/etc/passwd')"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = postQuery(t, router, models.QueryRequest{SQL: "SELECT * FROM read_csv(?)", Params: []byte(`["` + filepath.Join(root, "allowed", "in.csv") + `"]`)})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+}
+
+func TestSandboxConfig(t *testing.T) {
+	t.Run("on by default for read-only deployments", func(t *testing.T) {
+		t.Setenv("GODUCK_READ_WRITE", "false")
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		assert.True(t, cfg.Sandbox)
+	})
+
+	t.Run("off by default for read-write deployments", func(t *testing.T) {
+		t.Setenv("GODUCK_READ_WRITE", "true")
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		assert.False(t, cfg.Sandbox)
+	})
+
+	t.Run("allowed roots", func(t *testing.T) {
+		t.Setenv("GODUCK_SANDBOX", "true")
+		t.Setenv("GODUCK_ALLOWED_DIRECTORIES", "/data, /exports/")
+		t.Setenv("GODUCK_ALLOWED_PATHS", "/srv/lookup.csv")
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/data", "/exports/"}, cfg.AllowedDirectories)
+		assert.Equal(t, []string{"/srv/lookup.csv"}, cfg.AllowedPaths)
+	})
+
+	t.Run("allowed roots must be absolute", func(t *testing.T) {
+		t.Setenv("GODUCK_SANDBOX", "true")
+		t.Setenv("GODUCK_ALLOWED_DIRECTORIES", "data")
+		_, err := config.Load()
+		assert.Error(t, err)
+	})
+
+	t.Run("allowed roots require the sandbox", func(t *testing.T) {
+		t.Setenv("GODUCK_SANDBOX", "false")
+		t.Setenv("GODUCK_ALLOWED_PATHS", "/srv/lookup.csv")
+		_, err := config.Load()
+		assert.Error(t, err)
+	})
+}
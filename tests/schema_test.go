@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestQuerySchema(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	sql := `SELECT 42 AS i, '42' AS s, 1.50::DECIMAL(10,2) AS d, 7::DECIMAL(18,0) AS whole,
+		[1, 2] AS l, {'a': 1} AS st, TIMESTAMPTZ '2024-01-01 00:00:00+00' AS ts`
+	body, _ := json.Marshal(models.QueryRequest{SQL: sql})
+
+	req := httptest.NewRequest("POST", "/query", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response models.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	expected := []models.ColumnSchema{
+		{Name: "i", Type: "INTEGER", Nullable: true},
+		{Name: "s", Type: "VARCHAR", Nullable: true},
+		{Name: "d", Type: "DECIMAL(10,2)", Precision: intPtr(10), Scale: intPtr(2), Nullable: true},
+		{Name: "whole", Type: "DECIMAL(18,0)", Precision: intPtr(18), Scale: intPtr(0), Nullable: true},
+		{Name: "l", Type: "INTEGER[]", Nullable: true},
+		{Name: "st", Type: `STRUCT("a" INTEGER)`, Nullable: true},
+		{Name: "ts", Type: "TIMESTAMPTZ", Nullable: true},
+	}
+	assert.Equal(t, expected, response.Schema)
+	assert.Equal(t, []string{"i", "s", "d", "whole", "l", "st", "ts"}, response.Columns)
+
+	// Scale is reported even when it is zero.
+	assert.Contains(t, w.Body.String(), `"precision":18,"scale":0`)
+}
+
+func TestQuerySchemaNDJSON(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	body, _ := json.Marshal(models.QueryRequest{SQL: "SELECT 1.5::DECIMAL(4,1) AS price", Format: "ndjson"})
+	req := httptest.NewRequest("POST", "/query", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	scanner := bufio.NewScanner(w.Body)
+	require.True(t, scanner.Scan())
+
+	var header models.StreamHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, []models.ColumnSchema{
+		{Name: "price", Type: "DECIMAL(4,1)", Precision: intPtr(4), Scale: intPtr(1), Nullable: true},
+	}, header.Schema)
+}
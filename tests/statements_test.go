@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lab1702/goduck/internal/statements"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementClassification(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	classifier, err := statements.NewClassifier(db)
+	require.NoError(t, err)
+	defer classifier.Close()
+
+	tests := []struct {
+		sql   string
+		class statements.Class
+	}{
+		{"SELECT * FROM test_table", statements.ClassSelect},
+		{"FROM test_table", statements.ClassSelect},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", statements.ClassSelect},
+		{"SELECT 1; SELECT 2", statements.ClassSelect},
+		{"SELECT ?::INTEGER", statements.ClassSelect},
+		{"DESCRIBE test_table", statements.ClassSelect},
+		{"PIVOT test_table ON name IN ('a', 'b') USING count(*)", statements.ClassSelect},
+		{"FROM test_table PIVOT (count(*) FOR name IN ('a', 'b'))", statements.ClassSelect},
+		{"UNPIVOT test_table ON name INTO NAME k VALUE v", statements.ClassSelect},
+		{"PIVOT test_table ON name USING count(*)", statements.ClassMulti},
+		{"EXPLAIN SELECT 1", statements.ClassExplain},
+		{"EXPLAIN ANALYZE SELECT 1", statements.ClassExplain},
+		{"EXPLAIN (FORMAT json) SELECT 1", statements.ClassExplain},
+		{"EXPLAIN ANALYZE DELETE FROM test_table", statements.ClassDML},
+		{"EXPLAIN (ANALYZE) DROP TABLE test_table", statements.ClassDDL},
+		{"/* plan */ EXPLAIN SELECT 1", statements.ClassOther},
+		{"INSERT INTO test_table VALUES (3, 'x')", statements.ClassDML},
+		{"UPDATE test_table SET name = 'x'", statements.ClassDML},
+		{"DELETE FROM test_table", statements.ClassDML},
+		{"CREATE TABLE t2 (id INTEGER)", statements.ClassDDL},
+		{"DROP TABLE test_table", statements.ClassDDL},
+		{"ATTACH ':memory:' AS other", statements.ClassAttach},
+		{"COPY test_table TO 'out.csv'", statements.ClassCopy},
+		{"SET threads = 1", statements.ClassSet},
+		{"INSTALL httpfs", statements.ClassExtension},
+		{"BEGIN TRANSACTION", statements.ClassTransaction},
+		{"CHECKPOINT", statements.ClassCall},
+		{"VACUUM", statements.ClassMaintenance},
+		{"SELECT 1; DROP TABLE test_table", statements.ClassMulti},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			class, err := classifier.Classify(context.Background(), tt.sql)
+			require.NoError(t, err)
+			assert.Equal(t, tt.class, class)
+		})
+	}
+
+	// Classification must never run the statement.
+	var count int
+	require.NoError(t, db.GetConnection().QueryRow("SELECT count(*) FROM test_table").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	_, err = classifier.Classify(context.Background(), "SELEC 1")
+	assert.Error(t, err)
+}
+
+func TestParseClasses(t *testing.T) {
+	classes, err := statements.ParseClasses(" select , DML ")
+	require.NoError(t, err)
+	assert.Equal(t, []statements.Class{statements.ClassSelect, statements.ClassDML}, classes)
+
+	classes, err = statements.ParseClasses("all")
+	require.NoError(t, err)
+	assert.Contains(t, classes, statements.ClassMulti)
+
+	_, err = statements.ParseClasses("select,delete")
+	assert.Error(t, err)
+}
+
+func TestStatementPolicy(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	w := postQuery(t, router, models.QueryRequest{SQL: "SELECT count(*) FROM test_table"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, sql := range []string{
+		"DROP TABLE test_table",
+		"SELECT 1; DROP TABLE test_table",
+		"INSERT INTO test_table VALUES (3, 'x')",
+	} {
+		w := postQuery(t, router, models.QueryRequest{SQL: sql})
+		assert.Equal(t, http.StatusForbidden, w.Code, sql)
+
+		var response models.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "statement_not_allowed", response.Code)
+	}
+
+	// Nothing was executed.
+	var count int
+	require.NoError(t, db.GetConnection().QueryRow("SELECT count(*) FROM test_table").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	w = postQuery(t, router, models.QueryRequest{SQL: "SELEC 1"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestStatementPolicyJobs(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupJobRouter(t, db, defaultJobConfig())
+
+	b, _ := json.Marshal(models.QueryRequest{SQL: "SELECT 1; DELETE FROM test_table"})
+	req := httptest.NewRequest("POST", "/jobs", bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var response models.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "statement_not_allowed", response.Code)
+	assert.Equal(t, "multi statements are not allowed", response.Error)
+}
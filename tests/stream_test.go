@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lab1702/goduck/internal/results"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		accept    string
+		expected  results.Format
+		wantErr   bool
+	}{
+		{name: "default", expected: results.FormatJSON},
+		{name: "wildcard accept", accept: "*/*", expected: results.FormatJSON},
+		{name: "ndjson accept", accept: "application/x-ndjson", expected: results.FormatNDJSON},
+		{name: "ndjson with params", accept: "text/html, application/x-ndjson; q=0.9", expected: results.FormatNDJSON},
+		{name: "explicit overrides accept", requested: "json", accept: "application/x-ndjson", expected: results.FormatJSON},
+		{name: "explicit case insensitive", requested: "NDJSON", expected: results.FormatNDJSON},
+		{name: "unknown explicit", requested: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := results.Negotiate(tt.requested, tt.accept)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, f)
+		})
+	}
+}
+
+func TestQueryNDJSON(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		body   string
+	}{
+		{name: "accept header", path: "/query", accept: "application/x-ndjson", body: `{"sql": "SELECT i, i * 2 AS d FROM range(2500) t(i)"}`},
+		{name: "format field", path: "/query", body: `{"sql": "SELECT i, i * 2 AS d FROM range(2500) t(i)", "format": "ndjson"}`},
+		{name: "format query parameter", path: "/query?format=ndjson", body: `{"sql": "SELECT i, i * 2 AS d FROM range(2500) t(i)"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+			var lines [][]byte
+			scanner := bufio.NewScanner(w.Body)
+			for scanner.Scan() {
+				lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+			}
+			require.Len(t, lines, 2502)
+
+			var header models.StreamHeader
+			require.NoError(t, json.Unmarshal(lines[0], &header))
+			assert.Equal(t, []string{"i", "d"}, header.Columns)
+
+			var row []interface{}
+			require.NoError(t, json.Unmarshal(lines[2], &row))
+			assert.Equal(t, []interface{}{float64(1), float64(2)}, row)
+
+			var trailer models.StreamTrailer
+			require.NoError(t, json.Unmarshal(lines[len(lines)-1], &trailer))
+			assert.Equal(t, 2500, trailer.Count)
+			assert.NotEmpty(t, trailer.Time)
+			assert.Empty(t, trailer.Error)
+		})
+	}
+}
+
+func TestQueryNDJSONErrors(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	router := setupTestRouter(db)
+
+	t.Run("unknown format", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(`{"sql": "SELECT 1", "format": "xml"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("failure before streaming keeps error status", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/query", bytes.NewBufferString(`{"sql": "SELECT * FROM missing_table", "format": "ndjson"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	})
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/auth"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/tlsconfig"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pem    []byte
+	serial int64
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{
+		cert:   cert,
+		key:    key,
+		pem:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		serial: 1,
+	}
+}
+
+// issue returns a PEM certificate and key for subject, valid for localhost
+// servers or for clients.
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, server bool) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// clientCert returns a TLS client certificate for subject.
+func (ca *testCA) clientCert(t *testing.T, subject pkix.Name) tls.Certificate {
+	certPEM, keyPEM := ca.issue(t, subject, false)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return cert
+}
+
+// writeFile writes data to path and moves its modification time forward, so
+// that a rewrite within the file system's timestamp granularity is seen.
+func writeFile(t *testing.T, path string, data []byte) {
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// tlsFiles writes a server certificate issued by ca and returns reloader
+// options for it.
+func tlsFiles(t *testing.T, ca *testCA) tlsconfig.Options {
+	dir := t.TempDir()
+	options := tlsconfig.Options{
+		CertFile:   filepath.Join(dir, "server.crt"),
+		KeyFile:    filepath.Join(dir, "server.key"),
+		MinVersion: tls.VersionTLS12,
+	}
+	certPEM, keyPEM := ca.issue(t, pkix.Name{CommonName: "localhost"}, true)
+	writeFile(t, options.CertFile, certPEM)
+	writeFile(t, options.KeyFile, keyPEM)
+	return options
+}
+
+// serveTLS serves handler over TLS as main does and returns its URL.
+func serveTLS(t *testing.T, options tlsconfig.Options, handler http.Handler) string {
+	reloader, err := tlsconfig.NewReloader(options)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: handler, TLSConfig: reloader.Config()}
+	go srv.ServeTLS(listener, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + listener.Addr().String()
+}
+
+// tlsClient returns a client trusting ca that opens a new connection for
+// every request.
+func tlsClient(ca *testCA, configure func(*tls.Config)) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	config := &tls.Config{RootCAs: roots}
+	if configure != nil {
+		configure(config)
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true},
+	}
+}
+
+// servedSerial returns the serial number of the certificate the server at
+// url presents.
+func servedSerial(t *testing.T, client *http.Client, url string) int64 {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSServing(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("minimum version", func(t *testing.T) {
+		options := tlsFiles(t, ca)
+		options.MinVersion = tls.VersionTLS13
+		url := serveTLS(t, options, ok)
+
+		resp, err := tlsClient(ca, nil).Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+
+		_, err = tlsClient(ca, func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 }).Get(url)
+		assert.Error(t, err)
+	})
+
+	t.Run("certificate reload", func(t *testing.T) {
+		options := tlsFiles(t, ca)
+		url := serveTLS(t, options, ok)
+		client := tlsClient(ca, nil)
+		first := servedSerial(t, client, url)
+
+		certPEM, keyPEM := ca.issue(t, pkix.Name{CommonName: "localhost"}, true)
+		writeFile(t, options.CertFile, certPEM)
+		writeFile(t, options.KeyFile, keyPEM)
+		second := servedSerial(t, client, url)
+		assert.NotEqual(t, first, second)
+
+		// A broken certificate is not served; the previous one is kept.
+		writeFile(t, options.CertFile, []byte("not a certificate"))
+		assert.Equal(t, second, servedSerial(t, client, url))
+	})
+
+	t.Run("reload interval", func(t *testing.T) {
+		options := tlsFiles(t, ca)
+		options.ReloadInterval = time.Hour
+		url := serveTLS(t, options, ok)
+		client := tlsClient(ca, nil)
+		first := servedSerial(t, client, url)
+
+		certPEM, keyPEM := ca.issue(t, pkix.Name{CommonName: "localhost"}, true)
+		writeFile(t, options.CertFile, certPEM)
+		writeFile(t, options.KeyFile, keyPEM)
+		assert.Equal(t, first, servedSerial(t, client, url))
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		options := tlsFiles(t, ca)
+		options.KeyFile = options.CertFile
+		_, err := tlsconfig.NewReloader(options)
+		assert.Error(t, err)
+
+		options = tlsFiles(t, ca)
+		options.ClientCAFile = filepath.Join(t.TempDir(), "ca.pem")
+		writeFile(t, options.ClientCAFile, []byte("no certificates"))
+		_, err = tlsconfig.NewReloader(options)
+		assert.Error(t, err)
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCA := newTestCA(t, "Server CA")
+	clientCA := newTestCA(t, "Client CA")
+	otherCA := newTestCA(t, "Other CA")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authenticator := auth.Chain{
+		testPrincipals,
+		auth.NewCertAuthenticator(map[string][]string{"analysts": {auth.ScopeQueryRead}}),
+	}
+	router.GET("/whoami", middleware.AuthMiddleware(authenticator), func(c *gin.Context) {
+		c.JSON(http.StatusOK, auth.PrincipalFrom(c))
+	})
+
+	whoami := func(t *testing.T, client *http.Client, url, apiKey string) (int, auth.Principal) {
+		req, err := http.NewRequest(http.MethodGet, url+"/whoami", nil)
+		require.NoError(t, err)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var principal auth.Principal
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&principal))
+		} else {
+			var response models.ErrorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+			principal.Name = response.Code
+		}
+		return resp.StatusCode, principal
+	}
+
+	ann := pkix.Name{CommonName: "ann", OrganizationalUnit: []string{"analysts"}, Organization: []string{"Example"}}
+	withCert := func(ca *testCA, subject pkix.Name) func(*tls.Config) {
+		cert := ca.clientCert(t, subject)
+		return func(c *tls.Config) { c.Certificates = []tls.Certificate{cert} }
+	}
+
+	newServer := func(t *testing.T, clientAuth tls.ClientAuthType) string {
+		options := tlsFiles(t, serverCA)
+		options.ClientCAFile = filepath.Join(t.TempDir(), "clients.pem")
+		options.ClientAuth = clientAuth
+		writeFile(t, options.ClientCAFile, clientCA.pem)
+		return serveTLS(t, options, router)
+	}
+
+	t.Run("required", func(t *testing.T) {
+		url := newServer(t, tls.RequireAndVerifyClientCert)
+
+		status, principal := whoami(t, tlsClient(serverCA, withCert(clientCA, ann)), url, "")
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "CN=ann,OU=analysts,O=Example", principal.Name)
+		assert.Equal(t, []string{"analysts"}, principal.Roles)
+		assert.Equal(t, []string{auth.ScopeQueryRead}, principal.Scopes)
+		assert.Equal(t, "ann", principal.Claims["common_name"])
+
+		// A credential in the request takes precedence over the certificate.
+		status, principal = whoami(t, tlsClient(serverCA, withCert(clientCA, ann)), url, "hr")
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "hal", principal.Name)
+
+		_, err := tlsClient(serverCA, nil).Get(url + "/whoami")
+		assert.Error(t, err, "clients without a certificate are refused")
+
+		_, err = tlsClient(serverCA, withCert(otherCA, ann)).Get(url + "/whoami")
+		assert.Error(t, err, "certificates from other CAs are refused")
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		url := newServer(t, tls.VerifyClientCertIfGiven)
+
+		status, principal := whoami(t, tlsClient(serverCA, withCert(clientCA, ann)), url, "")
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "CN=ann,OU=analysts,O=Example", principal.Name)
+
+		status, principal = whoami(t, tlsClient(serverCA, nil), url, "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, models.CodeUnauthenticated, principal.Name)
+
+		status, principal = whoami(t, tlsClient(serverCA, nil), url, "analyst")
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ann", principal.Name)
+
+		// Clients only send certificates issued by the CAs the server names.
+		status, _ = whoami(t, tlsClient(serverCA, withCert(otherCA, ann)), url, "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lab1702/goduck/internal/cursor"
+	"github.com/lab1702/goduck/internal/handlers"
+	"github.com/lab1702/goduck/internal/jobs"
+	"github.com/lab1702/goduck/internal/middleware"
+	"github.com/lab1702/goduck/internal/registry"
+	"github.com/lab1702/goduck/internal/tracing"
+	"github.com/lab1702/goduck/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// exportedSpan is the part of a span written by the file exporter that the
+// tests look at.
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct {
+		TraceID, SpanID string
+		Remote          bool
+	}
+	Attributes []struct {
+		Key   string
+		Value struct{ Value interface{} }
+	}
+	Links []struct {
+		SpanContext struct{ TraceID, SpanID string }
+	}
+	Status struct{ Code string }
+}
+
+func (s exportedSpan) attribute(key string) interface{} {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+	return nil
+}
+
+func readSpans(t *testing.T, path string) []exportedSpan {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var spans []exportedSpan
+	dec := json.NewDecoder(f)
+	for {
+		var span exportedSpan
+		err := dec.Decode(&span)
+		if errors.Is(err, io.EOF) {
+			return spans
+		}
+		require.NoError(t, err)
+		spans = append(spans, span)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    tracing.ExporterFile,
+		File:        path,
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.TracingMiddleware())
+
+	manager := jobs.NewManager(db, defaultJobConfig())
+	defer manager.Close()
+	queryHandler := handlers.NewQueryHandler(db, 10*time.Second, cursor.NewStore(time.Minute, 10, 1000, 10000), registry.New(), testPolicy(db, "select"), nil, nil)
+	jobHandler := handlers.NewJobHandler(manager, queryHandler)
+	api := router.Group("/", middleware.AuthMiddleware(testPrincipals))
+	api.POST("/query", queryHandler.ExecuteQuery)
+	api.POST("/jobs", jobHandler.Submit)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	send := func(path, requestID string, body models.QueryRequest, traced bool) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "analyst")
+		req.Header.Set("X-Request-ID", requestID)
+		if traced {
+			req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/query", "traced-json", models.QueryRequest{SQL: "SELECT * FROM range(3)"}, true)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("/query", "untraced-csv", models.QueryRequest{SQL: "SELECT * FROM range(3)", Format: "csv"}, false)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("/query", "failing", models.QueryRequest{SQL: "SELECT nope"}, false)
+	require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	w = send("/jobs", "job", models.QueryRequest{SQL: "SELECT 1"}, true)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var job models.JobStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+
+	submitted, ok := manager.Get(job.ID)
+	require.True(t, ok)
+	<-submitted.Done()
+	require.NoError(t, shutdown(context.Background()))
+
+	byRequest := make(map[string]map[string]exportedSpan)
+	for _, span := range readSpans(t, path) {
+		requestID, _ := span.attribute(string(tracing.RequestIDKey)).(string)
+		if byRequest[requestID] == nil {
+			byRequest[requestID] = make(map[string]exportedSpan)
+		}
+		byRequest[requestID][span.Name] = span
+	}
+
+	t.Run("incoming trace is continued", func(t *testing.T) {
+		spans := byRequest["traced-json"]
+		for _, name := range []string{"POST /query", "auth", "query.validate", "duckdb.query", "rows.scan", "response.encode"} {
+			require.Contains(t, spans, name)
+			assert.Equal(t, traceID, spans[name].SpanContext.TraceID, name)
+		}
+
+		server := spans["POST /query"]
+		assert.Equal(t, spanID, server.Parent.SpanID)
+		assert.True(t, server.Parent.Remote)
+		assert.EqualValues(t, http.StatusOK, server.attribute("http.response.status_code"))
+		assert.Equal(t, "/query", server.attribute("http.route"))
+
+		assert.Equal(t, server.SpanContext.SpanID, spans["auth"].Parent.SpanID)
+		assert.Equal(t, "ann", spans["auth"].attribute(string(tracing.PrincipalKey)))
+		assert.Equal(t, "select", spans["query.validate"].attribute(string(tracing.ClassKey)))
+		assert.Equal(t, "duckdb", spans["duckdb.query"].attribute("db.system"))
+		assert.Equal(t, "SELECT * FROM range(3)", spans["duckdb.query"].attribute("db.query.text"))
+		assert.EqualValues(t, 3, spans["rows.scan"].attribute(string(tracing.RowsKey)))
+	})
+
+	t.Run("new trace without traceparent", func(t *testing.T) {
+		spans := byRequest["untraced-csv"]
+		require.Contains(t, spans, "POST /query")
+		require.Contains(t, spans, "response.stream")
+		assert.NotEqual(t, traceID, spans["POST /query"].SpanContext.TraceID)
+		assert.False(t, spans["POST /query"].Parent.Remote)
+		assert.Equal(t, spans["POST /query"].SpanContext.TraceID, spans["response.stream"].SpanContext.TraceID)
+		assert.EqualValues(t, 3, spans["response.stream"].attribute(string(tracing.RowsKey)))
+	})
+
+	t.Run("errors are recorded", func(t *testing.T) {
+		spans := byRequest["failing"]
+		require.Contains(t, spans, "duckdb.query")
+		assert.Equal(t, "Error", spans["duckdb.query"].Status.Code)
+		assert.Equal(t, "Unset", spans["query.validate"].Status.Code)
+	})
+
+	t.Run("jobs are linked to the request submitting them", func(t *testing.T) {
+		spans := byRequest["job"]
+		require.Contains(t, spans, "job.run")
+		run := spans["job.run"]
+		assert.Equal(t, job.ID, run.attribute(string(tracing.JobIDKey)))
+		assert.NotEqual(t, traceID, run.SpanContext.TraceID)
+		require.Len(t, run.Links, 1)
+		assert.Equal(t, spans["POST /jobs"].SpanContext.SpanID, run.Links[0].SpanContext.SpanID)
+		assert.Equal(t, traceID, run.Links[0].SpanContext.TraceID)
+
+		require.Contains(t, spans, "duckdb.query")
+		assert.Equal(t, run.SpanContext.TraceID, spans["duckdb.query"].SpanContext.TraceID)
+	})
+}